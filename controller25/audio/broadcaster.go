@@ -2,76 +2,168 @@ package audio
 
 import (
     "encoding/binary"
+    "fmt"
     "log"
     "net"
     "net/http"
     "strings"
     "sync"
+    "sync/atomic"
+    "time"
+
+    "controller25/config"
 )
 
+// holdAfterAudio is how long the channel that last sent audio keeps the
+// stream once it goes quiet, so a reply on the same channel isn't cut off
+// by another. OP25 only sends audio during calls.
+const holdAfterAudio = 2 * time.Second
+
+// Broadcaster relays the PCM audio OP25 sends over UDP to HTTP clients. A
+// multi_rx.py receiver sends each channel to its own port; like a scanner,
+// the broadcaster then streams one channel at a time, holding on to it
+// until it has been quiet for holdAfterAudio.
 type Broadcaster struct {
-    udpAddr    string
+    udpAddrs   []string
     mu         sync.Mutex
     clients    map[chan []byte]struct{}
     quit       chan struct{}
-    conn       *net.UDPConn
+    quitOnce   sync.Once
+    conns      []*net.UDPConn // guarded by mu
     SampleRate int
     Channels   int
+
+    maxClients   int // limits are guarded by mu
+    clientBuffer int
+
+    holder int       // source streaming now, guarded by mu
+    heldAt time.Time // its last packet
+
+    packets    atomic.Uint64
+    bytes      atomic.Uint64
+    oddPackets atomic.Uint64
+    dropped    atomic.Uint64
+    held       atomic.Uint64
+    lastPacket atomic.Int64 // unix nanoseconds, 0 until the first packet
+}
+
+// Stats is a snapshot of the broadcaster's counters.
+type Stats struct {
+    Packets    uint64
+    Bytes      uint64
+    OddPackets uint64 // packets truncated to a whole number of samples
+    Dropped    uint64 // packets not delivered to a client whose buffer was full
+    Held       uint64 // packets not streamed while another channel held the stream
+    LastPacket time.Time // zero if no packet was received yet
+    Clients    int
 }
 
-func NewBroadcaster(udpAddr string) *Broadcaster {
+// NewBroadcaster relays the audio sent to cfg.UDPAddr, or with several
+// sources to each of sources.
+func NewBroadcaster(cfg config.AudioConfig, sources ...string) *Broadcaster {
+    if len(sources) == 0 {
+        sources = []string{cfg.UDPAddr}
+    }
     return &Broadcaster{
-        udpAddr:    udpAddr,
-        clients:    make(map[chan []byte]struct{}),
-        quit:       make(chan struct{}),
-        SampleRate: 8000,
-        Channels:   1,
+        udpAddrs:     sources,
+        clients:      make(map[chan []byte]struct{}),
+        quit:         make(chan struct{}),
+        SampleRate:   cfg.SampleRate,
+        Channels:     cfg.Channels,
+        maxClients:   cfg.MaxClients,
+        clientBuffer: cfg.ClientBuffer,
     }
 }
 
-func (a *Broadcaster) Start() {
-    addr, err := net.ResolveUDPAddr("udp", a.udpAddr)
-    if err != nil {
-        log.Fatalf("Failed to resolve UDP address: %v", err)
+// Start binds the UDP ports OP25 sends audio to and starts relaying it to
+// clients. A port that can't be bound is returned as an error rather than
+// ending the controller, which may be running other receivers.
+func (a *Broadcaster) Start() error {
+    var conns []*net.UDPConn
+    fail := func(err error) error {
+        for _, conn := range conns {
+            conn.Close()
+        }
+        return err
     }
-
-    conn, err := net.ListenUDP("udp", addr)
-    if err != nil {
-        log.Fatalf("Failed to listen on UDP: %v", err)
+    for _, udpAddr := range a.udpAddrs {
+        addr, err := net.ResolveUDPAddr("udp", udpAddr)
+        if err != nil {
+            return fail(fmt.Errorf("audio address %s: %v", udpAddr, err))
+        }
+        conn, err := net.ListenUDP("udp", addr)
+        if err != nil {
+            return fail(fmt.Errorf("audio: %v", err))
+        }
+        conn.SetReadBuffer(65536 * 10)
+        conns = append(conns, conn)
     }
-    a.conn = conn
-
-    conn.SetReadBuffer(65536 * 10)
+    a.mu.Lock()
+    select {
+    case <-a.quit:
+        // Shut down before it started
+        a.mu.Unlock()
+        return fail(fmt.Errorf("audio: broadcaster shut down"))
+    default:
+    }
+    a.conns = conns
+    a.mu.Unlock()
 
-    log.Printf("Audio broadcaster started on %s (PCM S16_LE, %dHz, %d channel)", a.udpAddr, a.SampleRate, a.Channels)
+    log.Printf("Audio broadcaster started on %s (PCM S16_LE, %dHz, %d channel)", strings.Join(a.udpAddrs, ", "), a.SampleRate, a.Channels)
+    for source, conn := range conns {
+        go a.receive(source, conn)
+    }
+    return nil
+}
 
-    go func() {
-        defer conn.Close()
-        const frameSize = 8000 * 2 / 10
-        buf := make([]byte, frameSize)
+// receive relays the packets arriving on conn, the source'th port.
+func (a *Broadcaster) receive(source int, conn *net.UDPConn) {
+    defer conn.Close()
+    frameSize := a.SampleRate * a.Channels * 2 / 10
+    buf := make([]byte, frameSize)
 
-        for {
-            select {
-            case <-a.quit:
-                return
-            default:
-                n, _, err := conn.ReadFromUDP(buf)
-                if err != nil {
-                    if !strings.Contains(err.Error(), "use of closed network connection") {
-                        log.Printf("UDP read error: %v", err)
-                    }
-                    return
+    for {
+        select {
+        case <-a.quit:
+            return
+        default:
+            n, _, err := conn.ReadFromUDP(buf)
+            if err != nil {
+                if !strings.Contains(err.Error(), "use of closed network connection") {
+                    log.Printf("UDP read error: %v", err)
                 }
+                return
+            }
 
-                if n > 0 {
-                    if n%2 != 0 {
-                        n--
-                    }
+            if n > 0 {
+                a.packets.Add(1)
+                a.bytes.Add(uint64(n))
+                a.lastPacket.Store(time.Now().UnixNano())
+                if n%2 != 0 {
+                    a.oddPackets.Add(1)
+                    n--
+                }
+                if a.hold(source) {
                     a.broadcast(buf[:n])
+                } else {
+                    a.held.Add(1)
                 }
             }
         }
-    }()
+    }
+}
+
+// hold reports whether source may stream now: it already does, or the one
+// that did has been quiet for holdAfterAudio.
+func (a *Broadcaster) hold(source int) bool {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    now := time.Now()
+    if source != a.holder && now.Sub(a.heldAt) < holdAfterAudio {
+        return false
+    }
+    a.holder, a.heldAt = source, now
+    return true
 }
 
 func (a *Broadcaster) broadcast(data []byte) {
@@ -81,10 +173,38 @@ func (a *Broadcaster) broadcast(data []byte) {
         select {
         case ch <- append([]byte{}, data...):
         default:
+            a.dropped.Add(1)
         }
     }
 }
 
+// SetLimits changes the listener limits; see config.AudioConfig. Clients
+// already connected keep their buffer and are never disconnected.
+func (a *Broadcaster) SetLimits(maxClients, clientBuffer int) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    a.maxClients = maxClients
+    a.clientBuffer = clientBuffer
+}
+
+func (a *Broadcaster) Stats() Stats {
+    a.mu.Lock()
+    clients := len(a.clients)
+    a.mu.Unlock()
+    stats := Stats{
+        Packets:    a.packets.Load(),
+        Bytes:      a.bytes.Load(),
+        OddPackets: a.oddPackets.Load(),
+        Dropped:    a.dropped.Load(),
+        Held:       a.held.Load(),
+        Clients:    clients,
+    }
+    if ns := a.lastPacket.Load(); ns != 0 {
+        stats.LastPacket = time.Unix(0, ns)
+    }
+    return stats
+}
+
 func (a *Broadcaster) ServeWAV(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "audio/wav")
     w.Header().Set("Cache-Control", "no-cache")
@@ -95,14 +215,13 @@ func (a *Broadcaster) ServeWAV(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    header := makeWavHeader(a.SampleRate, a.Channels)
-    if _, err := w.Write(header); err != nil {
+    a.mu.Lock()
+    ch := make(chan []byte, a.clientBuffer)
+    if a.maxClients > 0 && len(a.clients) >= a.maxClients {
+        a.mu.Unlock()
+        http.Error(w, "Too many audio clients", http.StatusServiceUnavailable)
         return
     }
-    flusher.Flush()
-
-    ch := make(chan []byte, 100)
-    a.mu.Lock()
     a.clients[ch] = struct{}{}
     a.mu.Unlock()
 
@@ -113,6 +232,12 @@ func (a *Broadcaster) ServeWAV(w http.ResponseWriter, r *http.Request) {
         close(ch)
     }()
 
+    header := makeWavHeader(a.SampleRate, a.Channels)
+    if _, err := w.Write(header); err != nil {
+        return
+    }
+    flusher.Flush()
+
     notify := r.Context().Done()
     for {
         select {
@@ -123,6 +248,8 @@ func (a *Broadcaster) ServeWAV(w http.ResponseWriter, r *http.Request) {
             flusher.Flush()
         case <-notify:
             return
+        case <-a.quit:
+            return
         }
     }
 }
@@ -145,9 +272,15 @@ func makeWavHeader(sampleRate, channels int) []byte {
     return header
 }
 
+// Shutdown stops receiving audio and ends all client streams. It is safe to
+// call more than once.
 func (a *Broadcaster) Shutdown() {
-    close(a.quit)
-    if a.conn != nil {
-        a.conn.Close()
-    }
+    a.quitOnce.Do(func() {
+        a.mu.Lock()
+        defer a.mu.Unlock()
+        close(a.quit)
+        for _, conn := range a.conns {
+            conn.Close()
+        }
+    })
 }
\ No newline at end of file
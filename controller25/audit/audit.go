@@ -0,0 +1,68 @@
+package audit
+
+import (
+    "log"
+    "sync"
+    "time"
+)
+
+// Entry records one command issued through the controller.
+type Entry struct {
+    Time     time.Time   `json:"time"`
+    Who      string      `json:"who"`
+    Receiver string      `json:"receiver"`
+    Command  string      `json:"command"`
+    Arg1     interface{} `json:"arg1"`
+    Arg2     interface{} `json:"arg2"`
+    Error    string      `json:"error,omitempty"`
+}
+
+// Log keeps the most recent entries in memory; every entry is also written
+// to the controller log so older history survives in journald.
+type Log struct {
+    mu         sync.Mutex
+    entries    []Entry
+    maxEntries int
+}
+
+func NewLog(maxEntries int) *Log {
+    return &Log{
+        entries:    make([]Entry, 0),
+        maxEntries: maxEntries,
+    }
+}
+
+func (l *Log) Record(e Entry) {
+    if e.Time.IsZero() {
+        e.Time = time.Now()
+    }
+    if e.Error != "" {
+        log.Printf("[audit] %s: %s %s(%v, %v) failed: %s", e.Who, e.Receiver, e.Command, e.Arg1, e.Arg2, e.Error)
+    } else {
+        log.Printf("[audit] %s: %s %s(%v, %v)", e.Who, e.Receiver, e.Command, e.Arg1, e.Arg2)
+    }
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.entries = append(l.entries, e)
+    if len(l.entries) > l.maxEntries {
+        l.entries = l.entries[len(l.entries)-l.maxEntries:]
+    }
+}
+
+// Entries returns a copy of the recorded entries, oldest first.
+func (l *Log) Entries() []Entry {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return append([]Entry{}, l.entries...)
+}
+
+// Resize changes how many entries are kept, dropping the oldest if needed.
+func (l *Log) Resize(maxEntries int) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.maxEntries = maxEntries
+    if len(l.entries) > l.maxEntries {
+        l.entries = l.entries[len(l.entries)-l.maxEntries:]
+    }
+}
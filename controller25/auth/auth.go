@@ -0,0 +1,134 @@
+package auth
+
+import (
+    "context"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "net/http"
+    "strings"
+    "sync"
+
+    "controller25/config"
+)
+
+type contextKey struct{}
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+    Name string
+    Role string
+}
+
+// Authenticator checks bearer tokens (or HTTP basic credentials) on every
+// request except the health probes. Read-only credentials may only use safe
+// methods; everything that changes state requires an admin credential.
+type Authenticator struct {
+    mu     sync.RWMutex
+    tokens []config.Credential
+    users  []config.Credential
+}
+
+func New(cfg config.AuthConfig) *Authenticator {
+    return &Authenticator{tokens: cfg.Tokens, users: cfg.Users}
+}
+
+// Update replaces the accepted credentials, e.g. after a config reload.
+// Requests already authenticated are not affected.
+func (a *Authenticator) Update(cfg config.AuthConfig) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    a.tokens = cfg.Tokens
+    a.users = cfg.Users
+}
+
+// Enabled reports whether any credentials are configured.
+func (a *Authenticator) Enabled() bool {
+    a.mu.RLock()
+    defer a.mu.RUnlock()
+    return len(a.tokens) > 0 || len(a.users) > 0
+}
+
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !a.Enabled() || isPublic(r.URL.Path) {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        id, ok := a.authenticate(r)
+        if !ok {
+            w.Header().Set("WWW-Authenticate", `Bearer realm="controller25"`)
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+        if !allowed(id.Role, r.Method) {
+            http.Error(w, "Forbidden (admin role required)", http.StatusForbidden)
+            return
+        }
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, id)))
+    })
+}
+
+// FromRequest returns the identity attached by Middleware, if any.
+func FromRequest(r *http.Request) (Identity, bool) {
+    id, ok := r.Context().Value(contextKey{}).(Identity)
+    return id, ok
+}
+
+// isPublic is the unauthenticated allow-list.
+func isPublic(path string) bool {
+    return path == "/health" || strings.HasPrefix(path, "/health/")
+}
+
+func allowed(role, method string) bool {
+    if role == config.RoleAdmin {
+        return true
+    }
+    return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (Identity, bool) {
+    a.mu.RLock()
+    defer a.mu.RUnlock()
+    if user, pass, ok := r.BasicAuth(); ok {
+        for _, c := range a.users {
+            if c.Name == user && secretMatches(c.Secret, pass) {
+                return Identity{Name: c.Name, Role: c.Role}, true
+            }
+        }
+        return Identity{}, false
+    }
+
+    token := ""
+    if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+        token = strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+    } else {
+        // Audio players and EventSource can't set headers, so accept ?token= too
+        q := r.URL.Query()
+        token = q.Get("token")
+        if token != "" {
+            q.Del("token")
+            r.URL.RawQuery = q.Encode()
+        }
+    }
+    if token == "" {
+        return Identity{}, false
+    }
+    for _, c := range a.tokens {
+        if secretMatches(c.Secret, token) {
+            return Identity{Name: c.Name, Role: c.Role}, true
+        }
+    }
+    return Identity{}, false
+}
+
+// secretMatches compares in constant time against a plain or sha256: secret.
+func secretMatches(secret, given string) bool {
+    if digest, ok := strings.CutPrefix(secret, "sha256:"); ok {
+        sum := sha256.Sum256([]byte(given))
+        given = hex.EncodeToString(sum[:])
+        secret = strings.ToLower(digest)
+    }
+    return subtle.ConstantTimeCompare([]byte(secret), []byte(given)) == 1
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "controller25/backup"
+    "controller25/config"
+    "controller25/mdns"
+    "controller25/multirx"
+)
+
+// Archives hold a config.ini and the TLS key, so this is generous
+const maxRestoreSize = 64 << 20
+
+var restoreMu sync.Mutex
+
+func registerBackupHandlers(reload func() (config.Changes, error), mdnsService *mdns.Service) {
+    // POST, not GET: the archive holds credentials, so read-only tokens
+    // must not be able to download it
+    http.HandleFunc("/api/backup", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        sources, skipped := backupSources(currentConfig.Load())
+        var buf bytes.Buffer
+        m, err := backup.Write(&buf, sources, skipped)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        name := fmt.Sprintf("controller25-%s-%s.tar.gz", m.Hostname, m.Created.Format("20060102T150405Z"))
+        w.Header().Set("Content-Type", "application/gzip")
+        w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+        _, _ = w.Write(buf.Bytes())
+    })
+
+    // Restores an archive from /api/backup, sent as the request body. The
+    // archive is verified and its config.ini validated before anything is
+    // written, the current state is saved to [backup] dir first, and then
+    // every file is replaced or none is.
+    http.HandleFunc("/api/restore", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        restoreMu.Lock()
+        defer restoreMu.Unlock()
+
+        fail := func(status int, err error) {
+            w.WriteHeader(status)
+            _ = json.NewEncoder(w).Encode(RestoreResponse{Error: err.Error()})
+        }
+        // rx.py only reads its trunk and tags files when it starts
+        if receivers.AnyRunning() {
+            fail(http.StatusConflict, fmt.Errorf("stop OP25 before restoring"))
+            return
+        }
+        archive, err := backup.Read(http.MaxBytesReader(w, r.Body, maxRestoreSize))
+        if err != nil {
+            fail(http.StatusBadRequest, err)
+            return
+        }
+        cfg := currentConfig.Load()
+        targets, tlsChanged, err := restoreTargets(cfg, archive)
+        if err != nil {
+            fail(http.StatusUnprocessableEntity, err)
+            return
+        }
+
+        sources, skipped := backupSources(cfg)
+        saved, err := backup.Save(cfg.Backup.Dir, "pre-restore", cfg.Backup.Keep, sources, skipped)
+        if err != nil {
+            fail(http.StatusInternalServerError, fmt.Errorf("pre-restore backup: %v", err))
+            return
+        }
+        if err := backup.Apply(targets); err != nil {
+            fail(http.StatusInternalServerError, fmt.Errorf("nothing restored: %v", err))
+            return
+        }
+        log.Printf("Restored backup from %s (%s), previous state saved to %s",
+            archive.Hostname, archive.Created.Format(time.RFC3339), saved)
+
+        resp := RestoreResponse{Restored: true, PreRestoreBackup: saved}
+        for _, t := range targets {
+            resp.Files = append(resp.Files, t.Path)
+        }
+        changes, err := reload()
+        if err != nil {
+            // Validated above, so only a changed environment gets here
+            resp.Error = "restored, but the configuration didn't reload: " + err.Error()
+        }
+        if tlsChanged {
+            changes.Restart = append(changes.Restart, "tls")
+        }
+        resp.Changes = &changes
+        if sys, err := config.ReadTrunkSystem(receivers.Default().Profile.TrunkFile); err == nil {
+            mdnsService.Set(map[string]string{"sys": sys.SysName})
+        }
+        _ = json.NewEncoder(w).Encode(resp)
+    })
+}
+
+// backupSources lists config.ini, every receiver's channel list,
+// multi_rx.py configuration and trunk file with the tags, whitelist and
+// blacklist files they reference, and the TLS certificate. Referenced files
+// that are missing or outside the data dir are returned as skipped.
+func backupSources(cfg *config.Config) (sources []backup.Source, skipped []string) {
+    sources = append(sources, backup.Source{Kind: backup.KindConfig, Disk: cfg.File})
+
+    seen := make(map[string]bool)
+    addData := func(name string, required bool) {
+        rel, err := dataPath(cfg, name)
+        if err != nil {
+            skipped = append(skipped, fmt.Sprintf("%s: %v", name, err))
+            return
+        }
+        if seen[rel] {
+            return
+        }
+        seen[rel] = true
+        disk := filepath.Join(cfg.Server.DataDir, rel)
+        if _, err := os.Stat(disk); err != nil {
+            if required {
+                skipped = append(skipped, fmt.Sprintf("%s: %v", name, err))
+            }
+            return
+        }
+        sources = append(sources, backup.Source{Kind: backup.KindData, Path: rel, Disk: disk})
+    }
+    for _, rx := range receivers.All() {
+        addData(rx.Profile.ChannelsFile, false)
+        addData(rx.Profile.MultiRxFile, false)
+        addData(rx.Profile.TrunkFile, false)
+        if mrx, err := multirx.Read(rx.Profile.MultiRxFile); err == nil {
+            for _, name := range mrx.Files() {
+                addData(name, true)
+            }
+        }
+        sys, err := config.ReadTrunkSystem(rx.Profile.TrunkFile)
+        if err != nil {
+            continue
+        }
+        for _, name := range []string{sys.TagsFile, sys.Whitelist, sys.Blacklist} {
+            if name != "" {
+                addData(name, true)
+            }
+        }
+    }
+
+    if cfg.TLS.Enabled {
+        for kind, disk := range map[string]string{backup.KindTLSCert: cfg.TLS.CertFile, backup.KindTLSKey: cfg.TLS.KeyFile} {
+            if _, err := os.Stat(disk); err == nil {
+                sources = append(sources, backup.Source{Kind: kind, Disk: disk})
+            }
+        }
+    }
+    return sources, skipped
+}
+
+// dataPath makes name relative to the data dir and refuses names outside it.
+func dataPath(cfg *config.Config, name string) (string, error) {
+    rel, err := filepath.Rel(cfg.Server.DataDir, cfg.DataPath(name))
+    if err != nil {
+        return "", err
+    }
+    if !filepath.IsLocal(rel) {
+        return "", fmt.Errorf("outside the data dir")
+    }
+    return rel, nil
+}
+
+// restoreTargets maps the archive onto this controller's paths, which may
+// differ from the ones it was taken on, and validates its config.ini by
+// loading it. Data files go to the data_dir of the restored config.ini, the
+// certificate and key to the paths being served from. tlsChanged reports a
+// certificate that differs from the one being served.
+func restoreTargets(cfg *config.Config, archive *backup.Archive) (targets []backup.Target, tlsChanged bool, err error) {
+    _, data, ok := archive.File(backup.KindConfig)
+    if !ok {
+        return nil, false, fmt.Errorf("archive has no config.ini")
+    }
+    // Relative paths in it resolve against the controller's working
+    // directory and its data_dir, not the file's location; next to the real
+    // file is simply somewhere the controller may write
+    check := filepath.Join(filepath.Dir(cfg.File), ".config.ini.check")
+    if err := os.WriteFile(check, data, 0600); err != nil {
+        return nil, false, err
+    }
+    restored, err := config.Load(check)
+    os.Remove(check)
+    if err != nil {
+        return nil, false, fmt.Errorf("config.ini: %v", err)
+    }
+    targets = append(targets, backup.Target{Path: cfg.File, Data: data})
+
+    for _, e := range archive.Files {
+        var path string
+        switch e.Kind {
+        case backup.KindData:
+            // The restored config.ini's data_dir, which is where they are
+            // looked for once it is in effect
+            path = filepath.Join(restored.Server.DataDir, e.Path)
+        case backup.KindTLSCert:
+            path = cfg.TLS.CertFile
+        case backup.KindTLSKey:
+            path = cfg.TLS.KeyFile
+        default:
+            continue
+        }
+        data := archive.Data[e.Name]
+        if e.Kind != backup.KindData {
+            if current, err := os.ReadFile(path); err != nil || !bytes.Equal(current, data) {
+                tlsChanged = true
+            }
+        }
+        targets = append(targets, backup.Target{Path: path, Data: data})
+    }
+    return targets, tlsChanged, nil
+}
@@ -0,0 +1,338 @@
+package backup
+
+import (
+    "archive/tar"
+    "bytes"
+    "compress/gzip"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "controller25/version"
+)
+
+// FormatVersion is the archive layout written by Write. Read accepts this
+// version and older ones.
+const FormatVersion = 1
+
+const manifestName = "manifest.json"
+
+// Limits on what Read unpacks, so a small compressed archive can't expand
+// into more than a Pi's memory: config, trunk and tags files are a few
+// kilobytes each.
+const (
+    MaxFileSize  = 16 << 20 // one member
+    MaxTotalSize = 64 << 20 // all members
+    MaxFiles     = 1000
+)
+
+// Kinds of archived files, which decide where Restore puts them back.
+const (
+    KindConfig  = "config"   // config.ini
+    KindData    = "op25"     // files the controller manages, relative to the data dir (named for the OP25 apps dir they used to live in)
+    KindTLSCert = "tls_cert" // the controller's certificate and key, so pinned fingerprints survive
+    KindTLSKey  = "tls_key"
+)
+
+// Manifest is the archive's first member. It lists every other member with
+// a checksum, so a damaged or edited archive is refused as a whole.
+type Manifest struct {
+    Format   int       `json:"format"`
+    Version  string    `json:"controller_version"`
+    Created  time.Time `json:"created"`
+    Hostname string    `json:"hostname"`
+    Files    []Entry   `json:"files"`
+    Skipped  []string  `json:"skipped,omitempty"` // referenced files that weren't archived, and why
+}
+
+// Entry is one archived file. Path is relative to the data dir for KindData
+// and empty otherwise.
+type Entry struct {
+    Name   string `json:"name"`
+    Kind   string `json:"kind"`
+    Path   string `json:"path,omitempty"`
+    Size   int64  `json:"size"`
+    SHA256 string `json:"sha256"`
+}
+
+// Source is a file to archive: Disk is where it is now.
+type Source struct {
+    Kind string
+    Path string
+    Disk string
+}
+
+// Write archives sources as a gzipped tar with the manifest first.
+func Write(w io.Writer, sources []Source, skipped []string) (*Manifest, error) {
+    hostname, _ := os.Hostname()
+    m := &Manifest{
+        Format:   FormatVersion,
+        Version:  version.Version,
+        Created:  time.Now().UTC().Truncate(time.Second),
+        Hostname: hostname,
+        Skipped:  skipped,
+    }
+    var data [][]byte
+    for _, src := range sources {
+        b, err := os.ReadFile(src.Disk)
+        if err != nil {
+            return nil, err
+        }
+        sum := sha256.Sum256(b)
+        m.Files = append(m.Files, Entry{
+            Name:   memberName(src),
+            Kind:   src.Kind,
+            Path:   src.Path,
+            Size:   int64(len(b)),
+            SHA256: hex.EncodeToString(sum[:]),
+        })
+        data = append(data, b)
+    }
+    manifest, err := json.MarshalIndent(m, "", "  ")
+    if err != nil {
+        return nil, err
+    }
+
+    gz := gzip.NewWriter(w)
+    tw := tar.NewWriter(gz)
+    add := func(name string, b []byte) error {
+        hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(b)), ModTime: m.Created, Typeflag: tar.TypeReg}
+        if err := tw.WriteHeader(hdr); err != nil {
+            return err
+        }
+        _, err := tw.Write(b)
+        return err
+    }
+    if err := add(manifestName, manifest); err != nil {
+        return nil, err
+    }
+    for i, e := range m.Files {
+        if err := add(e.Name, data[i]); err != nil {
+            return nil, err
+        }
+    }
+    if err := tw.Close(); err != nil {
+        return nil, err
+    }
+    return m, gz.Close()
+}
+
+func memberName(src Source) string {
+    switch src.Kind {
+    case KindConfig:
+        return "config.ini"
+    case KindData:
+        return "op25/" + filepath.ToSlash(src.Path)
+    case KindTLSCert:
+        return "tls/cert.pem"
+    case KindTLSKey:
+        return "tls/key.pem"
+    }
+    return src.Kind
+}
+
+// Archive is a read and verified backup.
+type Archive struct {
+    Manifest
+    Data map[string][]byte // by Entry.Name
+}
+
+// Read reads and verifies an archive: a supported format, a manifest entry
+// for every member and the other way round, matching checksums and only
+// relative OP25 paths.
+func Read(r io.Reader) (*Archive, error) {
+    gz, err := gzip.NewReader(r)
+    if err != nil {
+        return nil, fmt.Errorf("not a backup archive: %v", err)
+    }
+    tr := tar.NewReader(gz)
+    a := &Archive{Data: make(map[string][]byte)}
+    var total int64
+    for {
+        hdr, err := tr.Next()
+        if errors.Is(err, io.EOF) {
+            break
+        }
+        if err != nil {
+            return nil, fmt.Errorf("not a backup archive: %v", err)
+        }
+        // Directories appear when an archive was unpacked and packed again
+        if hdr.Typeflag == tar.TypeDir {
+            continue
+        }
+        if hdr.Typeflag != tar.TypeReg {
+            return nil, fmt.Errorf("%s: not a regular file", hdr.Name)
+        }
+        if len(a.Data) >= MaxFiles {
+            return nil, fmt.Errorf("archive has more than %d files", MaxFiles)
+        }
+        // Read no more than the limit, whatever size the header claims
+        b, err := io.ReadAll(io.LimitReader(tr, MaxFileSize+1))
+        if err != nil {
+            return nil, err
+        }
+        if len(b) > MaxFileSize {
+            return nil, fmt.Errorf("%s: larger than %d MB", hdr.Name, MaxFileSize>>20)
+        }
+        if total += int64(len(b)); total > MaxTotalSize {
+            return nil, fmt.Errorf("archive unpacks to more than %d MB", MaxTotalSize>>20)
+        }
+        if _, dup := a.Data[hdr.Name]; dup {
+            return nil, fmt.Errorf("%s: archived twice", hdr.Name)
+        }
+        a.Data[hdr.Name] = b
+    }
+
+    manifest, ok := a.Data[manifestName]
+    if !ok {
+        return nil, fmt.Errorf("archive has no %s", manifestName)
+    }
+    delete(a.Data, manifestName)
+    if err := json.Unmarshal(manifest, &a.Manifest); err != nil {
+        return nil, fmt.Errorf("%s: %v", manifestName, err)
+    }
+    if a.Format < 1 || a.Format > FormatVersion {
+        return nil, fmt.Errorf("archive format %d is not supported (this controller reads up to %d)", a.Format, FormatVersion)
+    }
+
+    listed := make(map[string]bool)
+    for _, e := range a.Files {
+        b, ok := a.Data[e.Name]
+        if !ok {
+            return nil, fmt.Errorf("%s: listed in the manifest but missing", e.Name)
+        }
+        sum := sha256.Sum256(b)
+        if int64(len(b)) != e.Size || hex.EncodeToString(sum[:]) != e.SHA256 {
+            return nil, fmt.Errorf("%s: checksum mismatch", e.Name)
+        }
+        switch e.Kind {
+        case KindConfig, KindTLSCert, KindTLSKey:
+        case KindData:
+            if !filepath.IsLocal(e.Path) || "op25/"+path.Clean(filepath.ToSlash(e.Path)) != e.Name {
+                return nil, fmt.Errorf("%s: invalid path %q", e.Name, e.Path)
+            }
+        default:
+            return nil, fmt.Errorf("%s: unknown kind %q", e.Name, e.Kind)
+        }
+        listed[e.Name] = true
+    }
+    for name := range a.Data {
+        if !listed[name] {
+            return nil, fmt.Errorf("%s: not listed in the manifest", name)
+        }
+    }
+    return a, nil
+}
+
+// File returns the data of the first entry of the given kind.
+func (a *Archive) File(kind string) (Entry, []byte, bool) {
+    for _, e := range a.Files {
+        if e.Kind == kind {
+            return e, a.Data[e.Name], true
+        }
+    }
+    return Entry{}, nil, false
+}
+
+// Target is a file Apply writes.
+type Target struct {
+    Path string
+    Data []byte
+}
+
+// Apply writes every target or none: all data goes to temporary files next
+// to the targets first, and if renaming one into place fails, the files
+// already replaced are put back.
+func Apply(targets []Target) error {
+    var temps []string
+    cleanup := func() {
+        for _, tmp := range temps {
+            os.Remove(tmp)
+        }
+    }
+    for _, t := range targets {
+        if err := os.MkdirAll(filepath.Dir(t.Path), 0755); err != nil {
+            cleanup()
+            return err
+        }
+        tmp := t.Path + ".restore"
+        if err := os.WriteFile(tmp, t.Data, fileMode(t.Path)); err != nil {
+            cleanup()
+            return err
+        }
+        temps = append(temps, tmp)
+    }
+
+    type previous struct {
+        data   []byte
+        exists bool
+    }
+    var replaced []Target
+    var before []previous
+    for i, t := range targets {
+        old, err := os.ReadFile(t.Path)
+        prev := previous{data: old, exists: err == nil}
+        if err := os.Rename(temps[i], t.Path); err != nil {
+            for j := len(replaced) - 1; j >= 0; j-- {
+                if before[j].exists {
+                    os.WriteFile(replaced[j].Path, before[j].data, fileMode(replaced[j].Path))
+                } else {
+                    os.Remove(replaced[j].Path)
+                }
+            }
+            cleanup()
+            return err
+        }
+        replaced = append(replaced, t)
+        before = append(before, prev)
+    }
+    return nil
+}
+
+// fileMode keeps an existing file's permissions, which matters for the TLS
+// key and a config.ini holding secrets.
+func fileMode(path string) os.FileMode {
+    if fi, err := os.Stat(path); err == nil {
+        return fi.Mode().Perm()
+    }
+    if strings.HasSuffix(path, ".key") {
+        return 0600
+    }
+    return 0644
+}
+
+// Save writes an archive of sources into dir as <prefix>-<time>.tar.gz and
+// deletes the oldest ones with the same prefix beyond keep (0 keeps all).
+func Save(dir, prefix string, keep int, sources []Source, skipped []string) (string, error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return "", err
+    }
+    var buf bytes.Buffer
+    if _, err := Write(&buf, sources, skipped); err != nil {
+        return "", err
+    }
+    name := filepath.Join(dir, fmt.Sprintf("%s-%s.tar.gz", prefix, time.Now().UTC().Format("20060102T150405Z")))
+    if err := os.WriteFile(name, buf.Bytes(), 0600); err != nil {
+        return "", err
+    }
+
+    if keep > 0 {
+        // The timestamp sorts names by age
+        old, _ := filepath.Glob(filepath.Join(dir, prefix+"-*.tar.gz"))
+        sort.Strings(old)
+        for len(old) > keep {
+            os.Remove(old[0])
+            old = old[1:]
+        }
+    }
+    return name, nil
+}
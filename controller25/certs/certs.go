@@ -0,0 +1,106 @@
+package certs
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/hex"
+    "encoding/pem"
+    "fmt"
+    "log"
+    "math/big"
+    "net"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+const validity = 10 * 365 * 24 * time.Hour
+
+// EnsureSelfSigned generates a self-signed certificate and key at the given
+// paths unless both already exist. The certificate covers the hostname,
+// hostname.local, localhost and every local IP address, but clients are
+// expected to pin its fingerprint rather than rely on a CA.
+func EnsureSelfSigned(certFile, keyFile string) error {
+    _, certErr := os.Stat(certFile)
+    _, keyErr := os.Stat(keyFile)
+    if certErr == nil && keyErr == nil {
+        return nil
+    }
+    log.Printf("Generating self-signed TLS certificate %s", certFile)
+
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        return fmt.Errorf("failed to generate key: %v", err)
+    }
+    serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+    if err != nil {
+        return fmt.Errorf("failed to generate serial number: %v", err)
+    }
+
+    hostname, _ := os.Hostname()
+    tmpl := &x509.Certificate{
+        SerialNumber:          serial,
+        Subject:               pkix.Name{CommonName: "controller25 " + hostname, Organization: []string{"controller25"}},
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(validity),
+        KeyUsage:              x509.KeyUsageDigitalSignature,
+        ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+        BasicConstraintsValid: true,
+        DNSNames:              []string{"localhost"},
+        IPAddresses:           localIPs(),
+    }
+    if hostname != "" {
+        tmpl.DNSNames = append(tmpl.DNSNames, hostname, hostname+".local")
+    }
+
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+    if err != nil {
+        return fmt.Errorf("failed to create certificate: %v", err)
+    }
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil {
+        return fmt.Errorf("failed to encode key: %v", err)
+    }
+
+    for _, dir := range []string{filepath.Dir(certFile), filepath.Dir(keyFile)} {
+        if err := os.MkdirAll(dir, 0755); err != nil {
+            return err
+        }
+    }
+    if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+        return err
+    }
+    return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// Fingerprint returns the hex SHA-256 digest of the first certificate in certFile.
+func Fingerprint(certFile string) (string, error) {
+    data, err := os.ReadFile(certFile)
+    if err != nil {
+        return "", err
+    }
+    block, _ := pem.Decode(data)
+    if block == nil || block.Type != "CERTIFICATE" {
+        return "", fmt.Errorf("%s: no PEM certificate found", certFile)
+    }
+    sum := sha256.Sum256(block.Bytes)
+    return hex.EncodeToString(sum[:]), nil
+}
+
+func localIPs() []net.IP {
+    ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+    addrs, err := net.InterfaceAddrs()
+    if err != nil {
+        return ips
+    }
+    for _, addr := range addrs {
+        if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+            ips = append(ips, ipnet.IP)
+        }
+    }
+    return ips
+}
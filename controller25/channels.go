@@ -0,0 +1,132 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+
+    "controller25/config"
+    "controller25/receiver"
+)
+
+var errChannelNotFound = errors.New("channel not found")
+
+func registerChannelHandlers() {
+    // Lists (GET) or adds (POST) the receiver's conventional channels. The
+    // list is kept for trunked receivers too, so it can be set up before
+    // switching the receiver's mode.
+    handleReceiver("/api/channels", "/api/receivers/{id}/channels", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        switch r.Method {
+        case http.MethodGet:
+            channels, err := config.ReadChannels(rx.Profile.ChannelsFile)
+            if err != nil {
+                w.WriteHeader(http.StatusInternalServerError)
+                _ = json.NewEncoder(w).Encode(ChannelsResponse{Channels: []config.Channel{}, Error: err.Error()})
+                return
+            }
+            _ = json.NewEncoder(w).Encode(ChannelsResponse{Channels: channels})
+        case http.MethodPost:
+            ch, ok := decodeChannel(w, r)
+            if !ok {
+                return
+            }
+            _, err := config.UpdateChannels(rx.Profile.ChannelsFile, func(channels []config.Channel) ([]config.Channel, error) {
+                ch.ID = 1
+                for _, c := range channels {
+                    ch.ID = max(ch.ID, c.ID+1)
+                }
+                return append(channels, ch), nil
+            })
+            writeChannelResponse(w, rx, &ch, err)
+        default:
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    // Reads (GET), replaces (PUT) or deletes (DELETE) one channel.
+    handleReceiver("/api/channels/{cid}", "/api/receivers/{id}/channels/{cid}", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        cid, err := strconv.Atoi(r.PathValue("cid"))
+        if err != nil {
+            http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+            return
+        }
+        switch r.Method {
+        case http.MethodGet:
+            channels, err := config.ReadChannels(rx.Profile.ChannelsFile)
+            if err != nil {
+                writeChannelResponse(w, rx, nil, err)
+                return
+            }
+            for _, ch := range channels {
+                if ch.ID == cid {
+                    _ = json.NewEncoder(w).Encode(ChannelResponse{Success: true, Channel: &ch})
+                    return
+                }
+            }
+            writeChannelResponse(w, rx, nil, errChannelNotFound)
+        case http.MethodPut:
+            ch, ok := decodeChannel(w, r)
+            if !ok {
+                return
+            }
+            ch.ID = cid
+            _, err := config.UpdateChannels(rx.Profile.ChannelsFile, func(channels []config.Channel) ([]config.Channel, error) {
+                for i := range channels {
+                    if channels[i].ID == cid {
+                        channels[i] = ch
+                        return channels, nil
+                    }
+                }
+                return nil, errChannelNotFound
+            })
+            writeChannelResponse(w, rx, &ch, err)
+        case http.MethodDelete:
+            _, err := config.UpdateChannels(rx.Profile.ChannelsFile, func(channels []config.Channel) ([]config.Channel, error) {
+                for i := range channels {
+                    if channels[i].ID == cid {
+                        return append(channels[:i], channels[i+1:]...), nil
+                    }
+                }
+                return nil, errChannelNotFound
+            })
+            writeChannelResponse(w, rx, nil, err)
+        default:
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+}
+
+// decodeChannel reads and validates a channel from the request body,
+// answering the request itself when that fails.
+func decodeChannel(w http.ResponseWriter, r *http.Request) (config.Channel, bool) {
+    var ch config.Channel
+    if err := json.NewDecoder(r.Body).Decode(&ch); err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        _ = json.NewEncoder(w).Encode(ChannelResponse{Error: "Invalid request body"})
+        return ch, false
+    }
+    if err := ch.Validate(); err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        _ = json.NewEncoder(w).Encode(ChannelResponse{Error: err.Error()})
+        return ch, false
+    }
+    return ch, true
+}
+
+func writeChannelResponse(w http.ResponseWriter, rx *receiver.Receiver, ch *config.Channel, err error) {
+    switch {
+    case errors.Is(err, errChannelNotFound):
+        w.WriteHeader(http.StatusNotFound)
+        _ = json.NewEncoder(w).Encode(ChannelResponse{Error: err.Error()})
+    case err != nil:
+        w.WriteHeader(http.StatusInternalServerError)
+        _ = json.NewEncoder(w).Encode(ChannelResponse{Error: err.Error()})
+    default:
+        _ = json.NewEncoder(w).Encode(ChannelResponse{
+            Success:     true,
+            Channel:     ch,
+            Op25Restart: rx.Profile.Mode == config.ModeConventional && rx.Running(),
+        })
+    }
+}
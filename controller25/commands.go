@@ -0,0 +1,170 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+
+    "controller25/audit"
+    "controller25/auth"
+    "controller25/receiver"
+    "controller25/terminal"
+)
+
+// P25 talkgroup IDs are 16 bits
+const maxTgid = 65535
+
+// adj_tune is in Hz; anything beyond this is a typo, not fine tuning
+const maxTuneHz = 50000
+
+var auditLog *audit.Log // sized from [logs] audit_entries at startup
+
+// Command API types
+type Op25CommandRequest struct {
+    Tgid    int `json:"tgid"`
+    Amount  int `json:"amount"`
+    Channel int `json:"channel"`
+}
+type Op25CommandResponse struct {
+    Success bool              `json:"success"`
+    Result  []json.RawMessage `json:"result,omitempty"`
+    Error   string            `json:"error,omitempty"`
+}
+
+// commandBuilder validates a request and turns it into rx.py terminal commands.
+type commandBuilder func(req Op25CommandRequest) ([]terminal.Command, error)
+
+func registerCommandHandlers() {
+    handleCommand("hold", func(req Op25CommandRequest) ([]terminal.Command, error) {
+        if req.Tgid < 0 || req.Tgid > maxTgid {
+            return nil, fmt.Errorf("tgid must be between 0 (release) and %d", maxTgid)
+        }
+        cmds := []terminal.Command{}
+        // A held talkgroup must not be filtered out by the blacklist
+        if req.Tgid > 0 {
+            cmds = append(cmds, terminal.Command{Command: "whitelist", Arg1: req.Tgid, Arg2: req.Channel})
+        }
+        return append(cmds, terminal.Command{Command: "hold", Arg1: req.Tgid, Arg2: req.Channel}), nil
+    })
+    handleCommand("lockout", tgidCommand("lockout"))
+    handleCommand("whitelist", tgidCommand("whitelist"))
+    handleCommand("tune", func(req Op25CommandRequest) ([]terminal.Command, error) {
+        if req.Amount == 0 || req.Amount < -maxTuneHz || req.Amount > maxTuneHz {
+            return nil, fmt.Errorf("amount must be a non-zero offset between -%d and %d Hz", maxTuneHz, maxTuneHz)
+        }
+        return []terminal.Command{{Command: "adj_tune", Arg1: req.Amount, Arg2: req.Channel}}, nil
+    })
+    handleCommand("skip", simpleCommand("skip"))
+    handleCommand("capture", simpleCommand("capture"))
+    handleCommand("dump_tgids", simpleCommand("dump_tgids"))
+    handleCommand("dump_tracking", simpleCommand("dump_tracking"))
+    handleCommand("dump_buffer", simpleCommand("dump_buffer"))
+
+    // Every receiver's commands on the legacy route, one receiver's when scoped
+    auditHandler := func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        id := r.PathValue("id")
+        if _, ok := receivers.Get(id); id != "" && !ok {
+            http.Error(w, "Unknown receiver", http.StatusNotFound)
+            return
+        }
+        entries := []audit.Entry{}
+        for _, entry := range auditLog.Entries() {
+            if id == "" || entry.Receiver == id {
+                entries = append(entries, entry)
+            }
+        }
+        _ = json.NewEncoder(w).Encode(entries)
+    }
+    http.HandleFunc("/api/op25/audit", auditHandler)
+    http.HandleFunc("/api/receivers/{id}/audit", auditHandler)
+}
+
+func tgidCommand(command string) commandBuilder {
+    return func(req Op25CommandRequest) ([]terminal.Command, error) {
+        if req.Tgid < 1 || req.Tgid > maxTgid {
+            return nil, fmt.Errorf("tgid must be between 1 and %d", maxTgid)
+        }
+        return []terminal.Command{{Command: command, Arg1: req.Tgid, Arg2: req.Channel}}, nil
+    }
+}
+
+func simpleCommand(command string) commandBuilder {
+    return func(req Op25CommandRequest) ([]terminal.Command, error) {
+        return []terminal.Command{{Command: command, Arg1: 0, Arg2: req.Channel}}, nil
+    }
+}
+
+// handleCommand registers a POST endpoint at /api/op25/<name> and
+// /api/receivers/{id}/<name> that validates the request, relays the
+// resulting commands to the receiver's rx.py and audits every command sent.
+func handleCommand(name string, build commandBuilder) {
+    handleReceiver("/api/op25/"+name, "/api/receivers/{id}/"+name, func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        var req Op25CommandRequest
+        // Commands without arguments may be sent with an empty body
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+            w.WriteHeader(http.StatusBadRequest)
+            _ = json.NewEncoder(w).Encode(Op25CommandResponse{Success: false, Error: "Invalid request body"})
+            return
+        }
+        if req.Channel < 0 {
+            w.WriteHeader(http.StatusBadRequest)
+            _ = json.NewEncoder(w).Encode(Op25CommandResponse{Success: false, Error: "channel must not be negative"})
+            return
+        }
+        cmds, err := build(req)
+        if err != nil {
+            w.WriteHeader(http.StatusBadRequest)
+            _ = json.NewEncoder(w).Encode(Op25CommandResponse{Success: false, Error: err.Error()})
+            return
+        }
+
+        _, client, poller := rx.Terminal()
+        if client == nil {
+            w.WriteHeader(http.StatusServiceUnavailable)
+            _ = json.NewEncoder(w).Encode(Op25CommandResponse{Success: false, Error: "OP25 HTTP terminal not available"})
+            return
+        }
+
+        msgs, err := client.Send(cmds...)
+        who := requestIdentity(r)
+        for _, cmd := range cmds {
+            entry := audit.Entry{Who: who, Receiver: rx.ID, Command: cmd.Command, Arg1: cmd.Arg1, Arg2: cmd.Arg2}
+            if err != nil {
+                entry.Error = err.Error()
+            }
+            auditLog.Record(entry)
+        }
+        if err != nil {
+            w.WriteHeader(http.StatusBadGateway)
+            _ = json.NewEncoder(w).Encode(Op25CommandResponse{Success: false, Error: err.Error()})
+            return
+        }
+        if poller != nil {
+            poller.Ingest(msgs)
+        }
+
+        resp := Op25CommandResponse{Success: true}
+        for _, msg := range msgs {
+            resp.Result = append(resp.Result, msg.Data)
+        }
+        _ = json.NewEncoder(w).Encode(resp)
+    })
+}
+
+// requestIdentity names the issuer of a request for the audit log.
+func requestIdentity(r *http.Request) string {
+    if id, ok := auth.FromRequest(r); ok {
+        return fmt.Sprintf("%s@%s", id.Name, r.RemoteAddr)
+    }
+    return r.RemoteAddr
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+const ChannelsFileName = "channels.json"
+
+// Channel modulations. C4FM and CQPSK are P25; FM is analog.
+const (
+    ModulationC4FM  = "c4fm"
+    ModulationCQPSK = "cqpsk"
+    ModulationFM    = "fm"
+)
+
+// Channel is one conventional channel. Frequency is in MHz like trunk.tsv's
+// control channels. NAC only applies to P25 and is hex ("0x293"); empty
+// accepts any.
+type Channel struct {
+    ID         int     `json:"id"`
+    Label      string  `json:"label"`
+    Frequency  float64 `json:"frequency"`
+    NAC        string  `json:"nac,omitempty"`
+    Modulation string  `json:"modulation"`
+}
+
+// Validate checks the fields a client sets, filling in the default
+// modulation and normalizing the NAC.
+func (ch *Channel) Validate() error {
+    if ch.Frequency < 25 || ch.Frequency > 6000 {
+        return fmt.Errorf("frequency: %g MHz is out of range", ch.Frequency)
+    }
+    switch ch.Modulation {
+    case "":
+        ch.Modulation = ModulationC4FM
+    case ModulationC4FM, ModulationCQPSK, ModulationFM:
+    default:
+        return fmt.Errorf("modulation: unknown modulation %q (use %q, %q or %q)", ch.Modulation, ModulationC4FM, ModulationCQPSK, ModulationFM)
+    }
+    if ch.NAC != "" {
+        if ch.Modulation == ModulationFM {
+            return fmt.Errorf("nac: analog channels have no NAC")
+        }
+        n, err := ParseNAC(ch.NAC)
+        if err != nil {
+            return err
+        }
+        ch.NAC = fmt.Sprintf("0x%03x", n)
+    }
+    return nil
+}
+
+// ParseNAC parses a hex NAC, with or without 0x.
+func ParseNAC(nac string) (int, error) {
+    n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(nac), "0x"), 16, 12)
+    if err != nil {
+        return 0, fmt.Errorf("nac: %q is not a 12-bit hex value", nac)
+    }
+    return int(n), nil
+}
+
+// Lock for concurrent channels file access
+var channelsLock sync.Mutex
+
+// ReadChannels reads a channels file. A missing file is an empty list.
+func ReadChannels(filename string) ([]Channel, error) {
+    channelsLock.Lock()
+    defer channelsLock.Unlock()
+    return readChannels(filename)
+}
+
+func readChannels(filename string) ([]Channel, error) {
+    data, err := os.ReadFile(filename)
+    if errors.Is(err, os.ErrNotExist) {
+        return []Channel{}, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var file struct {
+        Channels []Channel `json:"channels"`
+    }
+    if err := json.Unmarshal(data, &file); err != nil {
+        return nil, fmt.Errorf("%s: %v", filename, err)
+    }
+    if file.Channels == nil {
+        file.Channels = []Channel{}
+    }
+    return file.Channels, nil
+}
+
+// UpdateChannels reads the channels file, lets update change the list and
+// writes the result back unless update fails.
+func UpdateChannels(filename string, update func([]Channel) ([]Channel, error)) ([]Channel, error) {
+    channelsLock.Lock()
+    defer channelsLock.Unlock()
+
+    channels, err := readChannels(filename)
+    if err != nil {
+        return nil, err
+    }
+    if channels, err = update(channels); err != nil {
+        return nil, err
+    }
+    data, err := json.MarshalIndent(struct {
+        Channels []Channel `json:"channels"`
+    }{channels}, "", "  ")
+    if err != nil {
+        return nil, err
+    }
+    return channels, writeFileAtomic(filename, append(data, '\n'))
+}
@@ -4,33 +4,601 @@ import (
     "gopkg.in/ini.v1"
     "io"
     "log"
+    "net"
     "os"
     "os/exec"
     "path/filepath"
     "syscall"
     "fmt"
+    "strconv"
+    "strings"
+    "time"
 )
 
+// Config is the controller configuration. Every field of a section maps to a
+// key of the config.ini section named by its ini tag and can be overridden
+// with a CONTROLLER25_<SECTION>_<KEY> environment variable. The reload tag
+// says how a changed value takes effect (see Reload); untagged fields need a
+// restart.
 type Config struct {
-    Op25RxPath string
+    Server ServerConfig `ini:"server"`
+    Op25   Op25Config   `ini:"op25"`
+    Audio  AudioConfig  `ini:"audio"`
+    Logs   LogsConfig   `ini:"logs"`
+    Health HealthConfig `ini:"health"`
+    MDNS   MDNSConfig   `ini:"mdns"`
+    SDR    SDRConfig    `ini:"sdr"`
+    RR     RRConfig     `ini:"radioreference"`
+    Backup BackupConfig `ini:"backup"`
+    TLS    TLSConfig    `ini:"tls"`
+    Auth   AuthConfig   `ini:"-"`
+
+    Receivers []ReceiverConfig `ini:"-"` // DefaultReceiver first
+
+    File         string   `ini:"-"` // absolute path of the loaded file
+    EnvOverrides []string `ini:"-"` // environment variables that were applied
+}
+
+// ServerConfig controls the HTTP server. ShutdownTimeout bounds the whole
+// shutdown sequence, including stopping OP25. DataDir holds the files the
+// controller manages (trunk, tags, channel and multi_rx.py files, backups);
+// it defaults to the OP25 apps dir, where they were kept before it existed.
+type ServerConfig struct {
+    Listen          string        `ini:"listen" reload:"restart"`
+    ShutdownTimeout time.Duration `ini:"shutdown_timeout" reload:"live"`
+    DataDir         string        `ini:"data_dir" reload:"restart"`
+}
+
+// Op25Config controls how the OP25 process is run and supervised. Stopping
+// sends SIGINT, then SIGTERM after InterruptTimeout, then SIGKILL after
+// TerminateTimeout; a zero timeout skips that step.
+type Op25Config struct {
+    RxPath           string        `ini:"rxpath" reload:"restart"`
+    Interpreter      string        `ini:"interpreter" reload:"op25"`
+    RxScript         string        `ini:"rx_script" reload:"op25"`
+    MultiRxScript    string        `ini:"multi_rx_script" reload:"op25"`
+    Env              []string      `ini:"env" delim:" " reload:"op25"`
+    Niceness         int           `ini:"niceness" reload:"op25"`
+    CPUs             string        `ini:"cpus" reload:"op25"`
+    IOClass          string        `ini:"io_class" reload:"op25"`
+    IOPriority       int           `ini:"io_priority" reload:"op25"`
+    MemoryLimit      string        `ini:"memory_limit" reload:"op25"`
+    CPULimit         time.Duration `ini:"cpu_limit" reload:"op25"`
+    Cgroup           string        `ini:"cgroup" reload:"op25"`
+    User             string        `ini:"user" reload:"op25"`
+    Group            string        `ini:"group" reload:"op25"`
+    EnvKeep          []string      `ini:"env_keep" delim:"," reload:"op25"`
+    WorkDir          string        `ini:"workdir" reload:"op25"`
+    InterruptTimeout time.Duration `ini:"stop_sigint_timeout" reload:"live"`
+    TerminateTimeout time.Duration `ini:"stop_sigterm_timeout" reload:"live"`
+    PollInterval     time.Duration `ini:"poll_interval" reload:"op25"`
+}
+
+// AudioConfig describes the PCM stream OP25 sends over UDP and limits who
+// can listen to it. MaxClients 0 means unlimited; ClientBuffer is the number
+// of packets queued per client before packets are dropped.
+type AudioConfig struct {
+    UDPAddr      string `ini:"udp_addr" reload:"op25"`
+    SampleRate   int    `ini:"sample_rate" reload:"op25"`
+    Channels     int    `ini:"channels" reload:"op25"`
+    MaxClients   int    `ini:"max_clients" reload:"live"`
+    ClientBuffer int    `ini:"client_buffer" reload:"live"`
+}
+
+// LogsConfig sizes the in-memory history kept for late subscribers.
+type LogsConfig struct {
+    HistoryLines int `ini:"history_lines" reload:"live"`
+    AuditEntries int `ini:"audit_entries" reload:"live"`
+}
+
+// HealthConfig sets when /health/ready reports OP25 as not ready: after no
+// audio for AudioStaleAfter, or no log line or decoded control channel
+// message for ActivityStaleAfter.
+type HealthConfig struct {
+    AudioStaleAfter    time.Duration `ini:"audio_stale_after" reload:"live"`
+    ActivityStaleAfter time.Duration `ini:"activity_stale_after" reload:"live"`
+}
+
+// SDRConfig names the tools GET /api/devices runs to enumerate SDRs; an
+// empty path skips that tool. Timeout bounds each run.
+type SDRConfig struct {
+    RTLTest   string        `ini:"rtl_test" reload:"live"`
+    RTLEEPROM string        `ini:"rtl_eeprom" reload:"live"`
+    SoapyUtil string        `ini:"soapy_util" reload:"live"`
+    Timeout   time.Duration `ini:"timeout" reload:"live"`
+}
+
+// RRConfig points the RadioReference importer at the SOAP API, or at a mock
+// of it. AppKey identifies this application to RadioReference; users still
+// log in with their own premium account.
+type RRConfig struct {
+    Endpoint string        `ini:"endpoint" reload:"live"`
+    AppKey   string        `ini:"app_key" reload:"live"`
+    Timeout  time.Duration `ini:"timeout" reload:"live"`
+}
+
+// BackupConfig is where POST /api/restore saves the automatic backup it
+// takes first, and how many of those to keep (0 keeps all). A relative Dir
+// is resolved against the directory the controller was started from.
+type BackupConfig struct {
+    Dir  string `ini:"dir" reload:"live"`
+    Keep int    `ini:"keep" reload:"live"`
+}
+
+// DefaultReceiver is the receiver the unscoped routes (/api/op25/...,
+// /audio.wav, /stream) act on. It always exists.
+const DefaultReceiver = "default"
+
+// ReceiverConfig is the profile of one OP25 instance, from a
+// [receiver.<id>] section. Flags are used when a start request has none.
+// AudioUDPAddr, HTTPAddr and TrunkFile, when set, replace whatever the flags
+// say (-W/-u, -l and -T), so receivers sharing a controller never collide.
+// Only the default receiver may leave AudioUDPAddr empty; it then listens
+// on [audio] udp_addr and its flags are passed through unchanged.
+// In conventional mode the receiver runs multi_rx.py on the channels in
+// ChannelsFile instead of rx.py, and Flags only pick the SDR (--args, -N,
+// -S, -q). In multi_rx mode it runs multi_rx.py on MultiRxFile, with the
+// audio destinations and terminal replaced by AudioUDPAddr and HTTPAddr.
+type ReceiverConfig struct {
+    ID           string   `ini:"-"`
+    Description  string   `ini:"description"`
+    Mode         string   `ini:"mode"`
+    Flags        []string `ini:"flags" delim:" "`
+    AudioUDPAddr string   `ini:"audio_udp_addr"`
+    HTTPAddr     string   `ini:"http_addr"`
+    TrunkFile    string   `ini:"trunk_file"`
+    ChannelsFile string   `ini:"channels_file"`
+    MultiRxFile  string   `ini:"multi_rx_file"`
+
+    // Override the [op25] keys of the same name when set; Script overrides
+    // rx_script or multi_rx_script depending on Mode and Env is added to
+    // [op25] env.
+    Interpreter string   `ini:"interpreter"`
+    Script      string   `ini:"script"`
+    WorkDir     string   `ini:"workdir"`
+    Env         []string `ini:"env" delim:" "`
+    Niceness    string   `ini:"niceness"`
+    CPUs        string   `ini:"cpus"`
+    IOClass     string   `ini:"io_class"`
+    IOPriority  string   `ini:"io_priority"`
+    MemoryLimit string   `ini:"memory_limit"`
+    CPULimit    string   `ini:"cpu_limit"`
+    Cgroup      string   `ini:"cgroup"`
+}
+
+// Receiver modes.
+const (
+    ModeTrunked      = "trunked"      // rx.py following a control channel from the trunk file
+    ModeConventional = "conventional" // multi_rx.py on a fixed channel list
+    ModeMultiRx      = "multi_rx"     // multi_rx.py on a configuration edited through the API
+)
+
+const MultiRxFileName = "multi_rx.json"
+
+// MDNSConfig controls the mDNS advertisement. An empty Instance derives a
+// name unique to this machine, Port 0 follows the HTTP listen port and no
+// Interfaces means all of them.
+type MDNSConfig struct {
+    Instance   string   `ini:"instance"`
+    Service    string   `ini:"service"`
+    Port       int      `ini:"port"`
+    Interfaces []string `ini:"interfaces" delim:","`
+}
+
+// TLSConfig enables HTTPS. Relative paths are resolved against the
+// directory the controller was started from.
+type TLSConfig struct {
+    Enabled  bool   `ini:"enabled"`
+    CertFile string `ini:"cert"`
+    KeyFile  string `ini:"key"`
+}
+
+// Roles a credential can be granted.
+const (
+    RoleRead  = "read"
+    RoleAdmin = "admin"
+)
+
+// AuthConfig lists the credentials accepted by the control API. Auth is
+// disabled when both lists are empty.
+type AuthConfig struct {
+    Tokens []Credential
+    Users  []Credential
+}
+
+// Credential is an API token or a username/password. Secret is the token or
+// password, either in plain text or as "sha256:<hex digest>".
+type Credential struct {
+    Name   string
+    Role   string
+    Secret string
+}
+
+// Default returns the configuration used for keys config.ini leaves out.
+func Default() *Config {
+    return &Config{
+        Server: ServerConfig{
+            Listen:          ":9000",
+            ShutdownTimeout: 20 * time.Second,
+        },
+        Op25: Op25Config{
+            Interpreter:      "python3",
+            RxScript:         "rx.py",
+            MultiRxScript:    "multi_rx.py",
+            Niceness:         -15,
+            IOPriority:       4,
+            InterruptTimeout: 5 * time.Second,
+            TerminateTimeout: 3 * time.Second,
+            PollInterval:     time.Second,
+        },
+        Audio: AudioConfig{
+            UDPAddr:      "127.0.0.1:23456",
+            SampleRate:   8000,
+            Channels:     1,
+            ClientBuffer: 100,
+        },
+        Logs: LogsConfig{
+            HistoryLines: 1000,
+            AuditEntries: 500,
+        },
+        Health: HealthConfig{
+            AudioStaleAfter:    time.Hour,
+            ActivityStaleAfter: 2 * time.Minute,
+        },
+        MDNS: MDNSConfig{
+            Service: "_op25mch._tcp",
+        },
+        SDR: SDRConfig{
+            RTLTest:   "rtl_test",
+            RTLEEPROM: "rtl_eeprom",
+            SoapyUtil: "SoapySDRUtil",
+            Timeout:   10 * time.Second,
+        },
+        RR: RRConfig{
+            Endpoint: "http://api.radioreference.com/soap2/",
+            AppKey:   "28801163", // the key the app ships with
+            Timeout:  30 * time.Second,
+        },
+        Backup: BackupConfig{
+            Dir:  "backups",
+            Keep: 5,
+        },
+        TLS: TLSConfig{
+            CertFile: "controller25.crt",
+            KeyFile:  "controller25.key",
+        },
+    }
 }
 
 func MustLoadConfig(filename string) *Config {
-    cfg, err := ini.Load(filename)
+    cfg, err := Load(filename)
     if err != nil {
         log.Fatalf("Failed to load config: %v", err)
     }
-    op25rxpath := cfg.Section("").Key("op25rxpath").String()
-    if op25rxpath == "" {
-        log.Fatalf("op25rxpath not found in config file")
+    return cfg
+}
+
+// Load reads filename, applies environment overrides and validates the
+// result.
+func Load(filename string) (*Config, error) {
+    path, err := filepath.Abs(filename)
+    if err != nil {
+        return nil, err
+    }
+    file, err := ini.Load(path)
+    if err != nil {
+        return nil, err
+    }
+    // op25rxpath predates the sections and is still the documented key
+    if root := file.Section(""); root.HasKey("op25rxpath") && !file.Section("op25").HasKey("rxpath") {
+        file.Section("op25").Key("rxpath").SetValue(root.Key("op25rxpath").String())
+    }
+
+    cfg := Default()
+    cfg.File = path
+    cfg.EnvOverrides = applyEnv(file, cfg)
+    for _, section := range cfg.sections() {
+        if err := file.Section(section.name).StrictMapTo(section.value.Addr().Interface()); err != nil {
+            return nil, fmt.Errorf("[%s] %v", section.name, err)
+        }
+    }
+    if cfg.Auth, err = loadAuthConfig(file); err != nil {
+        return nil, err
+    }
+    if cfg.Receivers, err = loadReceivers(file); err != nil {
+        return nil, err
+    }
+
+    // Paths are resolved once so nothing depends on the working directory:
+    // the OP25 apps dir and the data dir against the one the controller was
+    // started in, the files the controller manages against the data dir
+    if cfg.Op25.RxPath != "" {
+        if cfg.Op25.RxPath, err = filepath.Abs(cfg.Op25.RxPath); err != nil {
+            return nil, err
+        }
+    }
+    if cfg.Server.DataDir == "" {
+        cfg.Server.DataDir = cfg.Op25.RxPath
+    }
+    if cfg.Server.DataDir, err = filepath.Abs(cfg.Server.DataDir); err != nil {
+        return nil, err
+    }
+    if cfg.TLS.CertFile, err = filepath.Abs(cfg.TLS.CertFile); err != nil {
+        return nil, err
+    }
+    if cfg.TLS.KeyFile, err = filepath.Abs(cfg.TLS.KeyFile); err != nil {
+        return nil, err
+    }
+    cfg.Backup.Dir = cfg.DataPath(cfg.Backup.Dir)
+    for i := range cfg.Receivers {
+        rc := &cfg.Receivers[i]
+        rc.TrunkFile = cfg.DataPath(rc.TrunkFile)
+        rc.ChannelsFile = cfg.DataPath(rc.ChannelsFile)
+        rc.MultiRxFile = cfg.DataPath(rc.MultiRxFile)
+    }
+    if err := cfg.validate(); err != nil {
+        return nil, err
+    }
+    if cfg.MDNS.Port == 0 {
+        _, port, _ := net.SplitHostPort(cfg.Server.Listen)
+        cfg.MDNS.Port, _ = strconv.Atoi(port)
+    }
+    return cfg, nil
+}
+
+// validate checks values that parse but make no sense.
+func (c *Config) validate() error {
+    if _, port, err := net.SplitHostPort(c.Server.Listen); err != nil {
+        return fmt.Errorf("[server] listen: %v", err)
+    } else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+        return fmt.Errorf("[server] listen: invalid port %q", port)
+    }
+    if c.Server.ShutdownTimeout <= 0 {
+        return fmt.Errorf("[server] shutdown_timeout: must be positive")
+    }
+
+    if c.Op25.RxPath == "" {
+        return fmt.Errorf("op25rxpath not found in config file")
+    }
+    if _, err := parseResources(c.Op25.resourceKeys()); err != nil {
+        return fmt.Errorf("[op25] %v", err)
+    }
+    if _, _, err := c.Op25.credential(); err != nil {
+        return fmt.Errorf("[op25] %v", err)
+    }
+    if c.Op25.InterruptTimeout < 0 || c.Op25.TerminateTimeout < 0 {
+        return fmt.Errorf("[op25] stop timeouts must not be negative")
+    }
+    if c.Op25.PollInterval <= 0 {
+        return fmt.Errorf("[op25] poll_interval: must be positive")
+    }
+
+    if _, err := net.ResolveUDPAddr("udp", c.Audio.UDPAddr); err != nil {
+        return fmt.Errorf("[audio] udp_addr: %v", err)
+    }
+    if c.Audio.SampleRate <= 0 {
+        return fmt.Errorf("[audio] sample_rate: must be positive")
+    }
+    if c.Audio.Channels != 1 && c.Audio.Channels != 2 {
+        return fmt.Errorf("[audio] channels: must be 1 or 2")
+    }
+    if c.Audio.MaxClients < 0 {
+        return fmt.Errorf("[audio] max_clients: must not be negative")
+    }
+    if c.Audio.ClientBuffer < 1 {
+        return fmt.Errorf("[audio] client_buffer: must be at least 1")
+    }
+
+    if c.Logs.HistoryLines < 1 {
+        return fmt.Errorf("[logs] history_lines: must be at least 1")
+    }
+    if c.Logs.AuditEntries < 1 {
+        return fmt.Errorf("[logs] audit_entries: must be at least 1")
+    }
+
+    if c.Health.AudioStaleAfter <= 0 || c.Health.ActivityStaleAfter <= 0 {
+        return fmt.Errorf("[health] stale_after values must be positive")
+    }
+
+    if c.MDNS.Port < 0 || c.MDNS.Port > 65535 {
+        return fmt.Errorf("[mdns] port: %d out of range", c.MDNS.Port)
+    }
+
+    if c.SDR.Timeout <= 0 {
+        return fmt.Errorf("[sdr] timeout: must be positive")
+    }
+    if c.RR.Timeout <= 0 {
+        return fmt.Errorf("[radioreference] timeout: must be positive")
+    }
+    if c.Backup.Keep < 0 {
+        return fmt.Errorf("[backup] keep: must not be negative")
+    }
+    return c.validateReceivers()
+}
+
+// validateReceivers checks that every receiver has its own audio port,
+// terminal port, trunk file, channels file and multi_rx.py configuration.
+func (c *Config) validateReceivers() error {
+    audioAddrs := make(map[string]string)
+    httpAddrs := make(map[string]string)
+    trunkFiles := make(map[string]string)
+    channelsFiles := make(map[string]string)
+    multiRxFiles := make(map[string]string)
+    for _, rc := range c.Receivers {
+        section := "receiver." + rc.ID
+        if _, err := c.ReceiverProcess(rc); err != nil {
+            return fmt.Errorf("[%s] %v", section, err)
+        }
+        if rc.Mode != ModeTrunked && rc.Mode != ModeConventional && rc.Mode != ModeMultiRx {
+            return fmt.Errorf("[%s] mode: unknown mode %q (use %q, %q or %q)", section, rc.Mode, ModeTrunked, ModeConventional, ModeMultiRx)
+        }
+        audioAddr := rc.AudioUDPAddr
+        if audioAddr == "" {
+            if rc.ID != DefaultReceiver {
+                return fmt.Errorf("[%s] audio_udp_addr: required", section)
+            }
+            audioAddr = c.Audio.UDPAddr
+        }
+        addr, err := net.ResolveUDPAddr("udp", audioAddr)
+        if err != nil {
+            return fmt.Errorf("[%s] audio_udp_addr: %v", section, err)
+        }
+        if other, ok := audioAddrs[strconv.Itoa(addr.Port)]; ok {
+            return fmt.Errorf("[%s] audio_udp_addr: port %d already used by receiver %q", section, addr.Port, other)
+        }
+        audioAddrs[strconv.Itoa(addr.Port)] = rc.ID
+
+        if rc.HTTPAddr != "" {
+            _, port, err := net.SplitHostPort(rc.HTTPAddr)
+            if err != nil {
+                return fmt.Errorf("[%s] http_addr: %v", section, err)
+            }
+            if other, ok := httpAddrs[port]; ok {
+                return fmt.Errorf("[%s] http_addr: port %s already used by receiver %q", section, port, other)
+            }
+            httpAddrs[port] = rc.ID
+        }
+
+        if other, ok := trunkFiles[rc.TrunkFile]; ok {
+            return fmt.Errorf("[%s] trunk_file: %s already used by receiver %q", section, rc.TrunkFile, other)
+        }
+        trunkFiles[rc.TrunkFile] = rc.ID
+
+        if other, ok := channelsFiles[rc.ChannelsFile]; ok {
+            return fmt.Errorf("[%s] channels_file: %s already used by receiver %q", section, rc.ChannelsFile, other)
+        }
+        channelsFiles[rc.ChannelsFile] = rc.ID
+
+        if other, ok := multiRxFiles[rc.MultiRxFile]; ok {
+            return fmt.Errorf("[%s] multi_rx_file: %s already used by receiver %q", section, rc.MultiRxFile, other)
+        }
+        multiRxFiles[rc.MultiRxFile] = rc.ID
     }
-    return &Config{Op25RxPath: op25rxpath}
+    return nil
 }
 
-func MustChdir(path string) {
-    if err := os.Chdir(path); err != nil {
-        log.Fatalf("Failed to change working directory: %v", err)
+// loadAuthConfig reads [auth.tokens] and [auth.users], where every key is a
+// token or user name and every value is "<role>:<secret>".
+func loadAuthConfig(cfg *ini.File) (AuthConfig, error) {
+    var auth AuthConfig
+    for _, section := range []struct {
+        name string
+        list *[]Credential
+    }{
+        {"auth.tokens", &auth.Tokens},
+        {"auth.users", &auth.Users},
+    } {
+        if !cfg.HasSection(section.name) {
+            continue
+        }
+        for _, key := range cfg.Section(section.name).Keys() {
+            role, secret, ok := strings.Cut(key.String(), ":")
+            if !ok || secret == "" {
+                return auth, fmt.Errorf("[%s] %s: expected <role>:<secret>", section.name, key.Name())
+            }
+            if role != RoleRead && role != RoleAdmin {
+                return auth, fmt.Errorf("[%s] %s: unknown role %q (use %q or %q)", section.name, key.Name(), role, RoleRead, RoleAdmin)
+            }
+            *section.list = append(*section.list, Credential{Name: key.Name(), Role: role, Secret: secret})
+        }
     }
+    return auth, nil
+}
+
+// loadReceivers reads the [receiver.<id>] sections in file order, adding
+// the default receiver first if it isn't configured. Receivers other than
+// the default get trunk-<id>.tsv, channels-<id>.json and multi_rx-<id>.json
+// unless trunk_file, channels_file and multi_rx_file say otherwise.
+func loadReceivers(cfg *ini.File) ([]ReceiverConfig, error) {
+    receivers := []ReceiverConfig{{ID: DefaultReceiver, Mode: ModeTrunked, TrunkFile: TrunkFileName, ChannelsFile: ChannelsFileName, MultiRxFile: MultiRxFileName}}
+    for _, section := range cfg.Sections() {
+        id, ok := strings.CutPrefix(section.Name(), "receiver.")
+        if !ok {
+            continue
+        }
+        if !validReceiverID(id) {
+            return nil, fmt.Errorf("[%s]: receiver IDs may only use a-z, 0-9, - and _", section.Name())
+        }
+        rc := ReceiverConfig{
+            ID:           id,
+            Mode:         ModeTrunked,
+            TrunkFile:    "trunk-" + id + ".tsv",
+            ChannelsFile: "channels-" + id + ".json",
+            MultiRxFile:  "multi_rx-" + id + ".json",
+        }
+        if id == DefaultReceiver {
+            rc.TrunkFile = TrunkFileName
+            rc.ChannelsFile = ChannelsFileName
+            rc.MultiRxFile = MultiRxFileName
+        }
+        if err := section.StrictMapTo(&rc); err != nil {
+            return nil, fmt.Errorf("[%s] %v", section.Name(), err)
+        }
+        if id == DefaultReceiver {
+            receivers[0] = rc
+        } else {
+            receivers = append(receivers, rc)
+        }
+    }
+    return receivers, nil
+}
+
+func validReceiverID(id string) bool {
+    if id == "" {
+        return false
+    }
+    for _, c := range id {
+        if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+            return false
+        }
+    }
+    return true
+}
+
+// DataPath resolves name against the data dir. Relative tags, whitelist
+// and blacklist files in trunk files are resolved with it too.
+func (c *Config) DataPath(name string) string {
+    if name == "" || filepath.IsAbs(name) {
+        return name
+    }
+    return filepath.Join(c.Server.DataDir, name)
+}
+
+// Op25Path resolves name against the OP25 apps dir.
+func (c *Config) Op25Path(name string) string {
+    if name == "" || filepath.IsAbs(name) {
+        return name
+    }
+    return filepath.Join(c.Op25.RxPath, name)
+}
+
+// RunFile is the file a receiver's OP25 app was last started with,
+// generated on every start: run-<id>.json for multi_rx.py's configuration,
+// run-<id>.tsv for rx.py's trunk file.
+func (c *Config) RunFile(rc ReceiverConfig, ext string) string {
+    return c.DataPath("run-" + rc.ID + ext)
+}
+
+// ChannelAudioAddrs returns where each of n multi_rx.py channels sends its
+// audio: channel i to addr's port plus i, so the receiver can tell them
+// apart.
+func ChannelAudioAddrs(addr string, n int) ([]string, error) {
+    host, port, err := net.SplitHostPort(addr)
+    if err != nil {
+        return nil, err
+    }
+    first, err := strconv.Atoi(port)
+    if err != nil {
+        return nil, fmt.Errorf("invalid port %q", port)
+    }
+    if first+n-1 > 65535 {
+        return nil, fmt.Errorf("%d channels need ports %d-%d, past 65535", n, first, first+n-1)
+    }
+    addrs := make([]string, n)
+    for i := range addrs {
+        addrs[i] = net.JoinHostPort(host, strconv.Itoa(first+i))
+    }
+    return addrs, nil
 }
 
 // Deprecated: do not use for startup! Only here for legacy usage, returns 4 values now.
@@ -47,22 +615,59 @@ func StartOp25ProcessUDP() (*exec.Cmd, io.ReadCloser, io.ReadCloser, error) {
         "-w",
         "-W", "127.0.0.1",
     }
-    return StartOp25ProcessUDPWithFlags(op25_args)
+    p := Process{Interpreter: "python3", Script: "rx.py", Resources: Resources{Niceness: Default().Op25.Niceness}}
+    return StartOp25ProcessUDPWithFlags(op25_args, p)
 }
 
-// Use this for all OP25 process starts; returns 4 values (cmd, stdout, stderr, error)
-func StartOp25ProcessUDPWithFlags(flags []string) (*exec.Cmd, io.ReadCloser, io.ReadCloser, error) {
-    var op25Cmd *exec.Cmd
+// Use this for all rx.py starts; returns 4 values (cmd, stdout, stderr, error)
+func StartOp25ProcessUDPWithFlags(flags []string, p Process) (*exec.Cmd, io.ReadCloser, io.ReadCloser, error) {
+    return StartOp25App(p, flags)
+}
 
-    var full_command []string
-    if filepath.Ext("rx.py") == ".py" {
-        full_command = append([]string{"-n", "-15", "python3", "rx.py"}, flags...)
-    } else {
-        full_command = append([]string{"-n", "-15", "./rx.py"}, flags...)
+// StartOp25App starts the OP25 app p describes (rx.py, multi_rx.py or a
+// fork's equivalent) with args; returns 4 values (cmd, stdout, stderr,
+// error). A cgroup v2 cgroup is joined as the process starts; the other
+// resources are set right after. Resources the controller isn't allowed to
+// set are logged and the app runs without them.
+func StartOp25App(p Process, args []string) (*exec.Cmd, io.ReadCloser, io.ReadCloser, error) {
+    full_command, err := p.command(args)
+    if err != nil {
+        return nil, nil, nil, err
     }
 
-    op25Cmd = exec.Command("nice", full_command...)
-    op25Cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+    res := p.Resources
+    cgroup := res.openCgroup()
+    op25Cmd, stdout, stderr, err := startOp25Command(p, full_command, cgroup)
+    if cgroup != nil {
+        cgroup.Close()
+        if err != nil {
+            // Kernels before 5.7 can't start a process in a cgroup
+            log.Printf("Warning: %v in cgroup %s, moving OP25 there once started instead", err, res.Cgroup)
+            op25Cmd, stdout, stderr, err = startOp25Command(p, full_command, nil)
+        } else {
+            res.Cgroup = ""
+        }
+    }
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    log.Printf("OP25 process started with PID: %d", op25Cmd.Process.Pid)
+    for _, err := range res.apply(op25Cmd.Process.Pid) {
+        log.Printf("Warning: OP25 process %d: %v", op25Cmd.Process.Pid, err)
+    }
+    return op25Cmd, stdout, stderr, nil
+}
+
+// startOp25Command starts full_command as p says, in the cgroup the
+// directory cgroup is open on unless it is nil.
+func startOp25Command(p Process, full_command []string, cgroup *os.File) (*exec.Cmd, io.ReadCloser, io.ReadCloser, error) {
+    op25Cmd := exec.Command(full_command[0], full_command[1:]...)
+    op25Cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: p.Credential}
+    if cgroup != nil {
+        startInCgroup(op25Cmd.SysProcAttr, cgroup)
+    }
+    op25Cmd.Env = p.Env
+    op25Cmd.Dir = p.Dir
 
     stdout, err := op25Cmd.StdoutPipe()
     if err != nil {
@@ -77,6 +682,5 @@ func StartOp25ProcessUDPWithFlags(flags []string) (*exec.Cmd, io.ReadCloser, io.
     if err := op25Cmd.Start(); err != nil {
         return nil, nil, nil, fmt.Errorf("failed to start op25: %v", err)
     }
-    log.Printf("OP25 process started with PID: %d", op25Cmd.Process.Pid)
     return op25Cmd, stdout, stderr, nil
 }
\ No newline at end of file
@@ -0,0 +1,263 @@
+package config
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "os/user"
+    "path/filepath"
+    "slices"
+    "strconv"
+    "strings"
+    "syscall"
+)
+
+// Process is how an OP25 app is run: as whom, where, and with which
+// environment and resources.
+type Process struct {
+    Resources
+    Interpreter string              // "" runs Script itself
+    Script      string
+    Credential  *syscall.Credential // nil runs as the controller's user
+    Env         []string            // nil inherits the controller's
+    Dir         string              // where the app runs, "" for the controller's working directory
+}
+
+// ReceiverProcess returns how a receiver's OP25 app is run.
+func (c *Config) ReceiverProcess(rc ReceiverConfig) (Process, error) {
+    res, err := c.ReceiverResources(rc)
+    if err != nil {
+        return Process{}, err
+    }
+    credential, account, err := c.Op25.credential()
+    if err != nil {
+        return Process{}, err
+    }
+    p := Process{
+        Resources:   res,
+        Interpreter: c.Op25.Interpreter,
+        Script:      c.Op25.RxScript,
+        Credential:  credential,
+        Env:         processEnv(os.Environ(), c.Op25.EnvKeep, credential != nil, account),
+        Dir:         c.Op25.WorkDir,
+    }
+    if rc.Mode != ModeTrunked {
+        p.Script = c.Op25.MultiRxScript
+    }
+    if rc.Interpreter != "" {
+        p.Interpreter = rc.Interpreter
+    }
+    if rc.Script != "" {
+        p.Script = rc.Script
+    }
+    if rc.WorkDir != "" {
+        p.Dir = rc.WorkDir
+    }
+    // An empty value in config.ini keeps the default, so none means none
+    if p.Interpreter == "none" {
+        p.Interpreter = ""
+    }
+    if p.Script == "" {
+        return Process{}, fmt.Errorf("script: no OP25 app to run")
+    }
+    // OP25 runs in its apps dir unless told otherwise: rx.py's web terminal
+    // serves its files from there
+    p.Script = c.Op25Path(p.Script)
+    if strings.Contains(p.Interpreter, "/") {
+        p.Interpreter = c.Op25Path(p.Interpreter)
+    }
+    p.Dir = c.Op25Path(p.Dir)
+    if p.Dir == "" {
+        p.Dir = c.Op25.RxPath
+    }
+    for _, kv := range append(append([]string{}, c.Op25.Env...), rc.Env...) {
+        name, _, ok := strings.Cut(kv, "=")
+        if !ok || name == "" {
+            return Process{}, fmt.Errorf("env: %q is not NAME=value", kv)
+        }
+        if p.Env == nil {
+            p.Env = os.Environ()
+        }
+        p.Env = setEnv(p.Env, name, kv)
+    }
+    return p, nil
+}
+
+// Preflight checks that the interpreter, script and working directory
+// exist, so a misconfigured profile fails before anything is started.
+func (p Process) Preflight() error {
+    _, err := p.command(nil)
+    return err
+}
+
+// command returns the command line running the script with args, with the
+// interpreter looked up in PATH unless it is a path. Relative paths are made
+// absolute since Dir may differ from the working directory.
+func (p Process) command(args []string) ([]string, error) {
+    if p.Dir != "" {
+        if info, err := os.Stat(p.Dir); err != nil {
+            return nil, fmt.Errorf("workdir: %v", err)
+        } else if !info.IsDir() {
+            return nil, fmt.Errorf("workdir: %s is not a directory", p.Dir)
+        }
+    }
+    script, err := filepath.Abs(p.Script)
+    if err != nil {
+        return nil, err
+    }
+    if info, err := os.Stat(script); err != nil {
+        return nil, fmt.Errorf("script: %v", err)
+    } else if !info.Mode().IsRegular() {
+        return nil, fmt.Errorf("script: %s is not a file", script)
+    }
+    if p.Interpreter == "" {
+        if err := checkExecutable(script); err != nil {
+            return nil, fmt.Errorf("script: %v", err)
+        }
+        return append([]string{script}, args...), nil
+    }
+    interpreter := p.Interpreter
+    if strings.Contains(interpreter, "/") {
+        if interpreter, err = filepath.Abs(interpreter); err != nil {
+            return nil, err
+        }
+        err = checkExecutable(interpreter)
+    } else {
+        interpreter, err = exec.LookPath(interpreter)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("interpreter: %v", err)
+    }
+    return append([]string{interpreter, script}, args...), nil
+}
+
+func checkExecutable(path string) error {
+    info, err := os.Stat(path)
+    if err != nil {
+        return err
+    }
+    if info.IsDir() || info.Mode()&0111 == 0 {
+        return fmt.Errorf("%s is not executable", path)
+    }
+    return nil
+}
+
+// setEnv replaces or appends the variable name in env with kv.
+func setEnv(env []string, name, kv string) []string {
+    env = slices.DeleteFunc(env, func(v string) bool {
+        return strings.HasPrefix(v, name+"=")
+    })
+    return append(env, kv)
+}
+
+// credential resolves user and group, returning nil for the controller's
+// own. account is nil unless user is set. Supplementary groups are always
+// replaced, never inherited from the controller: the user's when it has a
+// passwd entry, otherwise just the primary group. Switching needs the
+// controller to run as root (or with CAP_SETUID and CAP_SETGID).
+func (o Op25Config) credential() (*syscall.Credential, *user.User, error) {
+    if o.User == "" && o.Group == "" {
+        return nil, nil, nil
+    }
+    credential := &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid()), Groups: []uint32{}}
+    var account *user.User
+    if o.User != "" {
+        var known bool
+        var err error
+        if account, known, err = lookupUser(o.User); err != nil {
+            return nil, nil, fmt.Errorf("user: %v", err)
+        }
+        uid, _ := strconv.ParseUint(account.Uid, 10, 32)
+        gid, _ := strconv.ParseUint(account.Gid, 10, 32)
+        credential.Uid, credential.Gid = uint32(uid), uint32(gid)
+        // Supplementary groups matter: SDR access is usually via plugdev
+        if known {
+            ids, err := account.GroupIds()
+            if err != nil {
+                return nil, nil, fmt.Errorf("user: groups of %s: %v", account.Username, err)
+            }
+            for _, id := range ids {
+                if gid, err := strconv.ParseUint(id, 10, 32); err == nil {
+                    credential.Groups = append(credential.Groups, uint32(gid))
+                }
+            }
+        }
+    }
+    if o.Group != "" {
+        gid, err := lookupGroup(o.Group)
+        if err != nil {
+            return nil, nil, fmt.Errorf("group: %v", err)
+        }
+        credential.Gid = gid
+    }
+    if len(credential.Groups) == 0 {
+        credential.Groups = []uint32{credential.Gid}
+    }
+    return credential, account, nil
+}
+
+// lookupUser finds a user by name or uid. A uid without a passwd entry is
+// used as is, with itself as the group and / as the home directory, and
+// known false.
+func lookupUser(name string) (u *user.User, known bool, err error) {
+    if u, err := user.Lookup(name); err == nil {
+        return u, true, nil
+    }
+    if _, err := strconv.ParseUint(name, 10, 32); err != nil {
+        return nil, false, fmt.Errorf("unknown user %q", name)
+    }
+    if u, err := user.LookupId(name); err == nil {
+        return u, true, nil
+    }
+    return &user.User{Uid: name, Gid: name, Username: name, HomeDir: "/"}, false, nil
+}
+
+// lookupGroup finds a group by name or gid.
+func lookupGroup(name string) (uint32, error) {
+    if g, err := user.LookupGroup(name); err == nil {
+        name = g.Gid
+    }
+    gid, err := strconv.ParseUint(name, 10, 32)
+    if err != nil {
+        return 0, fmt.Errorf("unknown group %q", name)
+    }
+    return uint32(gid), nil
+}
+
+// defaultEnvKeep is what OP25 keeps of the controller's environment when it
+// runs as another user or group; env_keep adds to it. A trailing * matches a
+// prefix.
+var defaultEnvKeep = []string{"PATH", "LANG", "LC_*", "TZ"}
+
+// processEnv returns the environment to run OP25 with. It is restricted to
+// defaultEnvKeep and keep when either restricted or keep is set, with HOME,
+// USER and LOGNAME describing account when it is set. nil inherits environ
+// unchanged.
+func processEnv(environ []string, keep []string, restricted bool, account *user.User) []string {
+    if len(keep) == 0 && !restricted {
+        return nil
+    }
+    keep = append(append([]string{}, defaultEnvKeep...), keep...)
+    env := []string{}
+    for _, kv := range environ {
+        name, _, _ := strings.Cut(kv, "=")
+        if !slices.ContainsFunc(keep, func(pattern string) bool { return envMatch(pattern, name) }) {
+            continue
+        }
+        if account != nil && (name == "HOME" || name == "USER" || name == "LOGNAME") {
+            continue
+        }
+        env = append(env, kv)
+    }
+    if account != nil {
+        env = append(env, "HOME="+account.HomeDir, "USER="+account.Username, "LOGNAME="+account.Username)
+    }
+    return env
+}
+
+func envMatch(pattern, name string) bool {
+    if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+        return strings.HasPrefix(name, prefix)
+    }
+    return pattern == name
+}
@@ -0,0 +1,57 @@
+package config
+
+import "reflect"
+
+// How a changed setting takes effect, from the reload tag.
+const (
+    ApplyLive    = "live"    // immediately
+    ApplyOp25    = "op25"    // the next time OP25 starts
+    ApplyRestart = "restart" // only when controller25 restarts
+)
+
+// Changes lists the settings a reload changed, as "section.key", by how
+// they take effect. Auth credentials are reported as "auth", any change to
+// the [receiver.<id>] sections as "receivers".
+type Changes struct {
+    Applied     []string `json:"applied"`
+    Op25Restart []string `json:"op25_restart"`
+    Restart     []string `json:"restart"`
+}
+
+// Reload compares next, freshly loaded, with the configuration in effect
+// and returns the one to switch to: next, except that settings needing a
+// restart keep their current value, so they are reported again by every
+// reload until the controller restarts.
+func (c *Config) Reload(next *Config) (*Config, Changes) {
+    merged := *next
+    changes := Changes{Applied: []string{}, Op25Restart: []string{}, Restart: []string{}}
+    current := c.sections()
+    for i, section := range merged.sections() {
+        old := current[i].value
+        for j := 0; j < section.value.NumField(); j++ {
+            field := section.value.Type().Field(j)
+            name := field.Tag.Get("ini")
+            if name == "" || name == "-" || reflect.DeepEqual(section.value.Field(j).Interface(), old.Field(j).Interface()) {
+                continue
+            }
+            key := section.name + "." + name
+            switch field.Tag.Get("reload") {
+            case ApplyLive:
+                changes.Applied = append(changes.Applied, key)
+            case ApplyOp25:
+                changes.Op25Restart = append(changes.Op25Restart, key)
+            default:
+                changes.Restart = append(changes.Restart, key)
+                section.value.Field(j).Set(old.Field(j))
+            }
+        }
+    }
+    if !reflect.DeepEqual(c.Auth, next.Auth) {
+        changes.Applied = append(changes.Applied, "auth")
+    }
+    if !reflect.DeepEqual(c.Receivers, next.Receivers) {
+        changes.Restart = append(changes.Restart, "receivers")
+        merged.Receivers = c.Receivers
+    }
+    return &merged, changes
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+    "fmt"
+    "path/filepath"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// I/O scheduling classes, numbered as the kernel does.
+const (
+    IOClassNone = iota // the kernel's default, derived from the niceness
+    IOClassRealtime
+    IOClassBestEffort
+    IOClassIdle
+)
+
+var ioClassNames = []string{"none", "realtime", "best-effort", "idle"}
+
+// Resources are the scheduling priorities and limits an OP25 process is
+// started with: [op25] merged with the receiver's overrides.
+type Resources struct {
+    Niceness    int
+    CPUs        []int         // affinity, empty for all
+    IOClass     int           // IOClassNone leaves the kernel default
+    IOPriority  int           // 0 (highest) to 7 within IOClass
+    MemoryLimit uint64        // RLIMIT_AS in bytes, 0 for unlimited
+    CPULimit    time.Duration // RLIMIT_CPU, 0 for unlimited
+    Cgroup      string        // cgroup v2 directory to start the process in
+}
+
+// EffectiveResources is what a running process got, read back from the
+// kernel. Limits of 0 are unlimited.
+type EffectiveResources struct {
+    Niceness    int    `json:"niceness"`
+    CPUs        []int  `json:"cpus"`
+    IOClass     string `json:"io_class"`
+    IOPriority  int    `json:"io_priority"`
+    MemoryLimit uint64 `json:"memory_limit"`
+    CPULimit    uint64 `json:"cpu_limit_seconds"`
+    Cgroup      string `json:"cgroup,omitempty"`
+}
+
+// resourceKeys are the resource settings as written in config.ini.
+type resourceKeys map[string]string
+
+func (o Op25Config) resourceKeys() resourceKeys {
+    return resourceKeys{
+        "niceness":     strconv.Itoa(o.Niceness),
+        "cpus":         o.CPUs,
+        "io_class":     o.IOClass,
+        "io_priority":  strconv.Itoa(o.IOPriority),
+        "memory_limit": o.MemoryLimit,
+        "cpu_limit":    o.CPULimit.String(),
+        "cgroup":       o.Cgroup,
+    }
+}
+
+// resourceOverrides returns the receiver's resource keys that are set.
+func (rc ReceiverConfig) resourceOverrides() resourceKeys {
+    keys := resourceKeys{}
+    for key, value := range map[string]string{
+        "niceness":     rc.Niceness,
+        "cpus":         rc.CPUs,
+        "io_class":     rc.IOClass,
+        "io_priority":  rc.IOPriority,
+        "memory_limit": rc.MemoryLimit,
+        "cpu_limit":    rc.CPULimit,
+        "cgroup":       rc.Cgroup,
+    } {
+        if value != "" {
+            keys[key] = value
+        }
+    }
+    return keys
+}
+
+// ReceiverResources returns what a receiver's OP25 process runs with.
+func (c *Config) ReceiverResources(rc ReceiverConfig) (Resources, error) {
+    keys := c.Op25.resourceKeys()
+    for key, value := range rc.resourceOverrides() {
+        keys[key] = value
+    }
+    return parseResources(keys)
+}
+
+func parseResources(keys resourceKeys) (Resources, error) {
+    var r Resources
+    var err error
+    if r.Niceness, err = strconv.Atoi(keys["niceness"]); err != nil || r.Niceness < -20 || r.Niceness > 19 {
+        return r, fmt.Errorf("niceness: %s out of range -20..19", keys["niceness"])
+    }
+    if r.CPUs, err = parseCPUList(keys["cpus"]); err != nil {
+        return r, fmt.Errorf("cpus: %v", err)
+    }
+    if name := keys["io_class"]; name != "" {
+        if r.IOClass = slices.Index(ioClassNames, name); r.IOClass < 0 {
+            return r, fmt.Errorf("io_class: unknown class %q (use realtime, best-effort or idle)", name)
+        }
+    }
+    if r.IOPriority, err = strconv.Atoi(keys["io_priority"]); err != nil || r.IOPriority < 0 || r.IOPriority > 7 {
+        return r, fmt.Errorf("io_priority: %s out of range 0..7", keys["io_priority"])
+    }
+    if r.MemoryLimit, err = parseSize(keys["memory_limit"]); err != nil {
+        return r, fmt.Errorf("memory_limit: %v", err)
+    }
+    if r.CPULimit, err = time.ParseDuration(keys["cpu_limit"]); err != nil || r.CPULimit < 0 {
+        return r, fmt.Errorf("cpu_limit: invalid duration %q", keys["cpu_limit"])
+    }
+    if r.Cgroup = keys["cgroup"]; r.Cgroup != "" && !filepath.IsAbs(r.Cgroup) {
+        r.Cgroup = filepath.Join("/sys/fs/cgroup", r.Cgroup)
+    }
+    return r, nil
+}
+
+// parseCPUList parses the kernel's list format, e.g. "0-2,4".
+func parseCPUList(list string) ([]int, error) {
+    var cpus []int
+    for _, part := range strings.Split(list, ",") {
+        part = strings.TrimSpace(part)
+        if part == "" {
+            continue
+        }
+        low, high, isRange := strings.Cut(part, "-")
+        first, err := strconv.Atoi(low)
+        last := first
+        if err == nil && isRange {
+            last, err = strconv.Atoi(high)
+        }
+        if err != nil || first < 0 || last < first || last >= 1024 {
+            return nil, fmt.Errorf("invalid CPU list %q", list)
+        }
+        for cpu := first; cpu <= last; cpu++ {
+            if !slices.Contains(cpus, cpu) {
+                cpus = append(cpus, cpu)
+            }
+        }
+    }
+    slices.Sort(cpus)
+    return cpus, nil
+}
+
+// parseSize parses a byte count with an optional K, M or G suffix (powers
+// of 1024). Empty is 0.
+func parseSize(size string) (uint64, error) {
+    size = strings.ToUpper(strings.TrimSpace(size))
+    if size == "" {
+        return 0, nil
+    }
+    multiplier := uint64(1)
+    for i, suffix := range []string{"K", "M", "G"} {
+        if trimmed, ok := strings.CutSuffix(strings.TrimSuffix(size, "B"), suffix); ok {
+            size, multiplier = trimmed, 1<<(10*(i+1))
+            break
+        }
+    }
+    n, err := strconv.ParseUint(size, 10, 64)
+    if err != nil {
+        return 0, fmt.Errorf("invalid size %q", size)
+    }
+    return n * multiplier, nil
+}
+
+// Mismatches lists what the process didn't get as configured, typically
+// because raising priority needs CAP_SYS_NICE.
+func (r Resources) Mismatches(e EffectiveResources) []string {
+    var out []string
+    if e.Niceness != r.Niceness {
+        out = append(out, fmt.Sprintf("niceness: configured %d, running at %d", r.Niceness, e.Niceness))
+    }
+    if len(r.CPUs) > 0 && !slices.Equal(r.CPUs, e.CPUs) {
+        out = append(out, fmt.Sprintf("cpus: configured %v, running on %v", r.CPUs, e.CPUs))
+    }
+    if r.IOClass != IOClassNone && (ioClassNames[r.IOClass] != e.IOClass || r.IOPriority != e.IOPriority) {
+        out = append(out, fmt.Sprintf("io: configured %s/%d, running with %s/%d", ioClassNames[r.IOClass], r.IOPriority, e.IOClass, e.IOPriority))
+    }
+    if r.MemoryLimit != e.MemoryLimit {
+        out = append(out, fmt.Sprintf("memory_limit: configured %d, running with %d", r.MemoryLimit, e.MemoryLimit))
+    }
+    if seconds := cpuSeconds(r.CPULimit); seconds != e.CPULimit {
+        out = append(out, fmt.Sprintf("cpu_limit: configured %ds, running with %ds", seconds, e.CPULimit))
+    }
+    if r.Cgroup != "" && !strings.HasSuffix(r.Cgroup, e.Cgroup) {
+        out = append(out, fmt.Sprintf("cgroup: configured %s, running in %s", r.Cgroup, e.Cgroup))
+    }
+    return out
+}
+
+// cpuSeconds rounds a CPU time limit up to RLIMIT_CPU's whole seconds.
+func cpuSeconds(d time.Duration) uint64 {
+    return uint64((d + time.Second - 1) / time.Second)
+}
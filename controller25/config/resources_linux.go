@@ -0,0 +1,200 @@
+//go:build linux
+
+package config
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "syscall"
+    "unsafe"
+)
+
+const (
+    ioprioWhoProcess  = 1
+    ioprioClassShift  = 13
+    cgroup2SuperMagic = 0x63677270
+)
+
+// cpuMask is a sched_setaffinity mask for up to 1024 CPUs, the kernel's
+// default CONFIG_NR_CPUS ceiling and the range parseCPUList accepts.
+type cpuMask [1024 / 64]uint64
+
+type rlimit64 struct {
+    Cur uint64
+    Max uint64
+}
+
+// apply sets r on the running process pid, returning what failed.
+func (r Resources) apply(pid int) []error {
+    var errs []error
+    if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, r.Niceness); err != nil {
+        errs = append(errs, fmt.Errorf("niceness %d: %v", r.Niceness, err))
+    }
+    if len(r.CPUs) > 0 {
+        var mask cpuMask
+        for _, cpu := range r.CPUs {
+            mask[cpu/64] |= 1 << (cpu % 64)
+        }
+        if _, _, e := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(pid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask))); e != 0 {
+            errs = append(errs, fmt.Errorf("cpus %v: %v", r.CPUs, e))
+        }
+    }
+    if r.IOClass != IOClassNone {
+        prio := r.IOClass<<ioprioClassShift | r.IOPriority
+        if _, _, e := syscall.RawSyscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(prio)); e != 0 {
+            errs = append(errs, fmt.Errorf("io_class %s: %v", ioClassNames[r.IOClass], e))
+        }
+    }
+    if r.MemoryLimit > 0 {
+        if err := setrlimit(pid, syscall.RLIMIT_AS, r.MemoryLimit); err != nil {
+            errs = append(errs, fmt.Errorf("memory_limit: %v", err))
+        }
+    }
+    if r.CPULimit > 0 {
+        if err := setrlimit(pid, syscall.RLIMIT_CPU, cpuSeconds(r.CPULimit)); err != nil {
+            errs = append(errs, fmt.Errorf("cpu_limit: %v", err))
+        }
+    }
+    if r.Cgroup != "" {
+        if err := joinCgroup(r.Cgroup, pid); err != nil {
+            errs = append(errs, fmt.Errorf("cgroup: %v", err))
+        }
+    }
+    return errs
+}
+
+// ReadResources reads back what the running process pid got.
+func ReadResources(pid int) (EffectiveResources, error) {
+    var e EffectiveResources
+    // The raw syscall returns 20 - nice so that it is never negative
+    prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, pid)
+    if err != nil {
+        return e, fmt.Errorf("niceness: %v", err)
+    }
+    e.Niceness = 20 - prio
+
+    var mask cpuMask
+    if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, uintptr(pid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask))); errno != 0 {
+        return e, fmt.Errorf("cpus: %v", errno)
+    }
+    e.CPUs = []int{}
+    for cpu := 0; cpu < len(mask)*64; cpu++ {
+        if mask[cpu/64]&(1<<(cpu%64)) != 0 {
+            e.CPUs = append(e.CPUs, cpu)
+        }
+    }
+
+    ioprio, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(pid), 0)
+    if errno != 0 {
+        return e, fmt.Errorf("io_class: %v", errno)
+    }
+    if class := int(ioprio >> ioprioClassShift); class < len(ioClassNames) {
+        e.IOClass = ioClassNames[class]
+    }
+    e.IOPriority = int(ioprio & 0xff)
+
+    // Unlike prlimit, /proc/<pid>/limits can be read for another user's process
+    limits, err := readLimits(pid)
+    if err != nil {
+        return e, fmt.Errorf("limits: %v", err)
+    }
+    e.MemoryLimit, e.CPULimit = limits["Max address space"], limits["Max cpu time"]
+
+    e.Cgroup = readCgroup(pid)
+    return e, nil
+}
+
+// readLimits returns the soft limits in /proc/<pid>/limits by name, leaving
+// out unlimited ones.
+func readLimits(pid int) (map[string]uint64, error) {
+    data, err := os.ReadFile(fmt.Sprintf("/proc/%d/limits", pid))
+    if err != nil {
+        return nil, err
+    }
+    limits := map[string]uint64{}
+    for _, line := range strings.Split(string(data), "\n") {
+        // Names are padded to 26 columns and contain spaces
+        if len(line) < 26 {
+            continue
+        }
+        fields := strings.Fields(line[26:])
+        if len(fields) == 0 {
+            continue
+        }
+        if n, err := strconv.ParseUint(fields[0], 10, 64); err == nil {
+            limits[strings.TrimSpace(line[:26])] = n
+        }
+    }
+    return limits, nil
+}
+
+// setrlimit sets both the soft and hard limit of another process.
+func setrlimit(pid int, resource int, limit uint64) error {
+    rlim := rlimit64{Cur: limit, Max: limit}
+    _, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource),
+        uintptr(unsafe.Pointer(&rlim)), 0, 0, 0)
+    if errno != 0 {
+        return errno
+    }
+    return nil
+}
+
+// openCgroup opens the cgroup v2 directory r.Cgroup so the process can be
+// started in it rather than moved there once it runs. nil when none is set
+// or it isn't on cgroup v2; apply then moves the process.
+func (r Resources) openCgroup() *os.File {
+    if r.Cgroup == "" {
+        return nil
+    }
+    var fs syscall.Statfs_t
+    if err := syscall.Statfs(r.Cgroup, &fs); err != nil || fs.Type != cgroup2SuperMagic {
+        return nil
+    }
+    dir, err := os.Open(r.Cgroup)
+    if err != nil {
+        return nil
+    }
+    return dir
+}
+
+// startInCgroup makes the process attr starts begin in the cgroup dir is
+// open on (clone3 with CLONE_INTO_CGROUP, Linux 5.7 and later).
+func startInCgroup(attr *syscall.SysProcAttr, dir *os.File) {
+    attr.UseCgroupFD = true
+    attr.CgroupFD = int(dir.Fd())
+}
+
+// joinCgroup moves pid into the cgroup v2 directory dir, which must exist:
+// creating it and delegating controllers is left to the system (e.g. a
+// systemd slice).
+func joinCgroup(dir string, pid int) error {
+    f, err := os.OpenFile(filepath.Join(dir, "cgroup.procs"), os.O_WRONLY, 0)
+    if err != nil {
+        return err
+    }
+    _, err = f.WriteString(strconv.Itoa(pid))
+    if closeErr := f.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+// readCgroup returns the process's cgroup v2 path, "" without cgroup v2.
+func readCgroup(pid int) string {
+    f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+    if err != nil {
+        return ""
+    }
+    defer f.Close()
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+            return path
+        }
+    }
+    return ""
+}
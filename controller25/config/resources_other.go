@@ -0,0 +1,34 @@
+//go:build !linux
+
+package config
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "syscall"
+)
+
+var errResourcesUnsupported = errors.New("not supported on this platform")
+
+// apply only sets the niceness outside Linux; the rest is reported.
+func (r Resources) apply(pid int) []error {
+    var errs []error
+    if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, r.Niceness); err != nil {
+        errs = append(errs, fmt.Errorf("niceness %d: %v", r.Niceness, err))
+    }
+    if len(r.CPUs) > 0 || r.IOClass != IOClassNone || r.MemoryLimit > 0 || r.CPULimit > 0 || r.Cgroup != "" {
+        errs = append(errs, fmt.Errorf("cpus, io_class, limits and cgroup: %v", errResourcesUnsupported))
+    }
+    return errs
+}
+
+func (r Resources) openCgroup() *os.File {
+    return nil
+}
+
+func startInCgroup(attr *syscall.SysProcAttr, dir *os.File) {}
+
+func ReadResources(pid int) (EffectiveResources, error) {
+    return EffectiveResources{}, errResourcesUnsupported
+}
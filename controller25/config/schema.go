@@ -0,0 +1,125 @@
+package config
+
+import (
+    "fmt"
+    "os"
+    "reflect"
+    "strings"
+    "time"
+
+    "gopkg.in/ini.v1"
+)
+
+// EnvPrefix starts the name of every environment override.
+const EnvPrefix = "CONTROLLER25_"
+
+// Redacted replaces secrets in Values.
+const Redacted = "<redacted>"
+
+type section struct {
+    name  string
+    value reflect.Value
+}
+
+// sections returns the struct of every config.ini section in Config.
+func (c *Config) sections() []section {
+    var sections []section
+    v := reflect.ValueOf(c).Elem()
+    for i := 0; i < v.NumField(); i++ {
+        name := v.Type().Field(i).Tag.Get("ini")
+        if name == "" || name == "-" || v.Field(i).Kind() != reflect.Struct {
+            continue
+        }
+        sections = append(sections, section{name, v.Field(i)})
+    }
+    return sections
+}
+
+// keys returns the config.ini key of every field in a section struct.
+func (s section) keys() []string {
+    var keys []string
+    for i := 0; i < s.value.NumField(); i++ {
+        if name := s.value.Type().Field(i).Tag.Get("ini"); name != "" && name != "-" {
+            keys = append(keys, name)
+        }
+    }
+    return keys
+}
+
+// EnvName returns the environment variable that overrides key in section.
+func EnvName(section, key string) string {
+    return EnvPrefix + strings.ToUpper(section+"_"+key)
+}
+
+// applyEnv copies every set override into file and returns the variables
+// that were applied.
+func applyEnv(file *ini.File, cfg *Config) []string {
+    var applied []string
+    for _, section := range cfg.sections() {
+        for _, key := range section.keys() {
+            name := EnvName(section.name, key)
+            if value, ok := os.LookupEnv(name); ok {
+                file.Section(section.name).Key(key).SetValue(value)
+                applied = append(applied, name)
+            }
+        }
+    }
+    return applied
+}
+
+// Values returns the effective configuration by section and key, formatted
+// as config.ini would spell it. Secrets are replaced by Redacted.
+func (c *Config) Values() map[string]map[string]string {
+    values := make(map[string]map[string]string)
+    for _, section := range c.sections() {
+        keys := make(map[string]string)
+        for i := 0; i < section.value.NumField(); i++ {
+            field := section.value.Type().Field(i)
+            name := field.Tag.Get("ini")
+            if name == "" || name == "-" {
+                continue
+            }
+            keys[name] = formatValue(section.value.Field(i), field.Tag.Get("delim"))
+        }
+        values[section.name] = keys
+    }
+    for name, list := range map[string][]Credential{"auth.tokens": c.Auth.Tokens, "auth.users": c.Auth.Users} {
+        keys := make(map[string]string)
+        for _, cred := range list {
+            keys[cred.Name] = cred.Role + ":" + Redacted
+        }
+        values[name] = keys
+    }
+    for _, rc := range c.Receivers {
+        values["receiver."+rc.ID] = map[string]string{
+            "description":    rc.Description,
+            "mode":           rc.Mode,
+            "flags":          strings.Join(rc.Flags, " "),
+            "audio_udp_addr": rc.AudioUDPAddr,
+            "http_addr":      rc.HTTPAddr,
+            "trunk_file":     rc.TrunkFile,
+            "channels_file":  rc.ChannelsFile,
+            "multi_rx_file":  rc.MultiRxFile,
+            "interpreter":    rc.Interpreter,
+            "script":         rc.Script,
+            "workdir":        rc.WorkDir,
+            "env":            strings.Join(rc.Env, " "),
+        }
+        for key, value := range rc.resourceOverrides() {
+            values["receiver."+rc.ID][key] = value
+        }
+    }
+    return values
+}
+
+// formatValue formats a field value; lists are joined with delim.
+func formatValue(v reflect.Value, delim string) string {
+    switch value := v.Interface().(type) {
+    case time.Duration:
+        return value.String()
+    case []string:
+        return strings.Join(value, delim)
+    default:
+        return fmt.Sprint(value)
+    }
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// Talkgroup is a line of an OP25 TGID tags file: the talkgroup ID, its tag
+// and optionally a priority (lower wins, 0 leaves rx.py's default).
+type Talkgroup struct {
+    TGID     int
+    Tag      string
+    Priority int
+}
+
+// ReadTags reads a tags file. Lines that don't start with a number, such as
+// a header, are skipped.
+func ReadTags(filename string) ([]Talkgroup, error) {
+    f, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    var tags []Talkgroup
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        cols := splitTSV(scanner.Text())
+        tgid, err := strconv.Atoi(strings.Trim(cols[0], `"`))
+        if err != nil {
+            continue
+        }
+        tg := Talkgroup{TGID: tgid}
+        if len(cols) > 1 {
+            tg.Tag = strings.Trim(cols[1], `"`)
+        }
+        if len(cols) > 2 {
+            tg.Priority, _ = strconv.Atoi(strings.Trim(cols[2], `"`))
+        }
+        tags = append(tags, tg)
+    }
+    return tags, scanner.Err()
+}
+
+// WriteTags replaces filename with one "<tgid>\t<tag>[\t<priority>]" line
+// per talkgroup, the format rx.py reads for the "TGID Tags File" column.
+func WriteTags(filename string, tags []Talkgroup) error {
+    var b strings.Builder
+    for _, tg := range tags {
+        // Tabs and newlines would shift columns
+        tag := strings.Join(strings.Fields(tg.Tag), " ")
+        if tg.Priority != 0 {
+            fmt.Fprintf(&b, "%d\t%s\t%d\n", tg.TGID, tag, tg.Priority)
+        } else {
+            fmt.Fprintf(&b, "%d\t%s\n", tg.TGID, tag)
+        }
+    }
+    return writeFileAtomic(filename, []byte(b.String()))
+}
+
+// writeFileAtomic writes through a temporary file so rx.py never reads a
+// half-written file.
+func writeFileAtomic(filename string, data []byte) error {
+    tmp := filename + ".tmp"
+    if err := os.WriteFile(tmp, data, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, filename)
+}
@@ -4,18 +4,31 @@ import (
     "bufio"
     "fmt"
     "os"
+    "path/filepath"
     "strings"
     "sync"
 )
 
 const TrunkFileName = "trunk.tsv"
 
-// TrunkSystem represents a row in trunk.tsv.
+// TrunkSystem represents a row in trunk.tsv. ControlChannel is the
+// comma-separated control channel list; empty columns are written with
+// rx.py's defaults.
 type TrunkSystem struct {
     SysName           string
     ControlChannel    string
+    Offset            string
+    NAC               string
+    Modulation        string
+    TagsFile          string
+    Whitelist         string
+    Blacklist         string
+    CenterFrequency   string
 }
 
+// trunkHeader names the columns in the order rx.py reads them.
+const trunkHeader = `"Sysname"	"Control Channel List"	"Offset"	"NAC"	"Modulation"	"TGID Tags File"	"Whitelist"	"Blacklist"	"Center Frequency"`
+
 // Lock for concurrent trunk.tsv access
 var trunkLock sync.Mutex
 
@@ -43,9 +56,22 @@ func ReadTrunkSystem(filename string) (*TrunkSystem, error) {
         if len(cols) < 2 {
             continue
         }
+        for len(cols) < 9 {
+            cols = append(cols, "")
+        }
+        for i := range cols {
+            cols[i] = unquoteTSV(cols[i])
+        }
         sys := TrunkSystem{
-            SysName:        strings.Trim(cols[0], `"`),
-            ControlChannel: strings.Trim(cols[1], `"`),
+            SysName:         cols[0],
+            ControlChannel:  cols[1],
+            Offset:          cols[2],
+            NAC:             cols[3],
+            Modulation:      cols[4],
+            TagsFile:        cols[5],
+            Whitelist:       cols[6],
+            Blacklist:       cols[7],
+            CenterFrequency: cols[8],
         }
         return &sys, nil
     }
@@ -59,7 +85,7 @@ func WriteTrunkSystem(filename string, sys *TrunkSystem) error {
 
     lines := []string{}
     foundHeader := false
-    header := `"Sysname"	"Control Channel List"	"Offset"	"NAC"	"Modulation"	"TGID Tags File"	"Whitelist"	"Blacklist"	"Center Frequency"`
+    header := trunkHeader
 
     // Read all lines if file exists
     if f, err := os.Open(filename); err == nil {
@@ -78,7 +104,10 @@ func WriteTrunkSystem(filename string, sys *TrunkSystem) error {
         foundHeader = true
     }
 
-    newRow := fmt.Sprintf(`"%s"	"%s"	"0"	"0"	"cqpsk"	""	""	""	""`, sys.SysName, sys.ControlChannel)
+    newRow := strings.Join([]string{
+        quoteTSV(sys.SysName), quoteTSV(sys.ControlChannel), quoteTSV(orDefault(sys.Offset, "0")), quoteTSV(orDefault(sys.NAC, "0")),
+        quoteTSV(orDefault(sys.Modulation, "cqpsk")), quoteTSV(sys.TagsFile), quoteTSV(sys.Whitelist), quoteTSV(sys.Blacklist), quoteTSV(sys.CenterFrequency),
+    }, "\t")
 
     if foundHeader && len(lines) > 1 {
         // Replace first data row
@@ -92,7 +121,60 @@ func WriteTrunkSystem(filename string, sys *TrunkSystem) error {
     }
 
     // Write back
-    return os.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+    return writeFileAtomic(filename, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// WriteRunTrunkFile copies the trunk file filename to runFile with every
+// system's relative tags, whitelist and blacklist files resolved against
+// dir, for rx.py, which would resolve them against its working directory.
+func WriteRunTrunkFile(filename, runFile, dir string) error {
+    trunkLock.Lock()
+    data, err := os.ReadFile(filename)
+    trunkLock.Unlock()
+    if err != nil {
+        return err
+    }
+    lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+    for i, line := range lines {
+        if i == 0 && strings.Contains(line, "Sysname") {
+            continue
+        }
+        cols := strings.Split(line, "\t")
+        // Tags, whitelist and blacklist columns
+        for c := 5; c <= 7 && c < len(cols); c++ {
+            name := unquoteTSV(strings.TrimSpace(cols[c]))
+            if name != "" && !filepath.IsAbs(name) {
+                cols[c] = quoteTSV(filepath.Join(dir, name))
+            }
+        }
+        lines[i] = strings.Join(cols, "\t")
+    }
+    return writeFileAtomic(runFile, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+func orDefault(value, fallback string) string {
+    if value == "" {
+        return fallback
+    }
+    return value
+}
+
+// quoteTSV quotes a trunk.tsv field the way rx.py's csv reader expects:
+// embedded quotes doubled, and tabs and newlines, which would shift
+// columns or rows, collapsed to spaces.
+func quoteTSV(value string) string {
+    value = strings.Join(strings.FieldsFunc(value, func(r rune) bool {
+        return r == '\t' || r == '\n' || r == '\r'
+    }), " ")
+    return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+}
+
+// unquoteTSV undoes quoteTSV; unquoted fields are returned as they are.
+func unquoteTSV(field string) string {
+    if len(field) >= 2 && strings.HasPrefix(field, `"`) && strings.HasSuffix(field, `"`) {
+        return strings.ReplaceAll(field[1:len(field)-1], `""`, `"`)
+    }
+    return field
 }
 
 // Helper: split a TSV row, trimming extra whitespace
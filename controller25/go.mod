@@ -0,0 +1,8 @@
+module controller25
+
+go 1.22
+
+require (
+	github.com/grandcat/zeroconf v1.0.0
+	gopkg.in/ini.v1 v1.67.0
+)
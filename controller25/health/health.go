@@ -1,9 +1,283 @@
 package health
 
 import (
+    "encoding/json"
+    "fmt"
     "net/http"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+
+    "controller25/version"
 )
 
-func ServeHealth(w http.ResponseWriter, r *http.Request) {
+// Thresholds says how long each kind of output may be missing before OP25
+// counts as not ready. OP25 only sends audio during calls, so Audio should
+// allow long quiet periods. Activity is rx.py logging, which it does
+// continuously at -v 9, or decoding the control channel.
+type Thresholds struct {
+    Audio    time.Duration
+    Activity time.Duration
+}
+
+// Sources is the live controller state a Checker reports on, gathered by
+// the caller on every request.
+type Sources struct {
+    Op25Running      bool
+    Op25PID          int
+    Op25StartedAt    time.Time
+    AudioPackets     uint64
+    AudioBytes       uint64
+    LastAudio        time.Time
+    AudioClients     int
+    AudioOddPackets  uint64
+    AudioDropped     uint64
+    AudioHeld        uint64
+    LogLines         uint64
+    LogLinesByStream map[string]uint64
+    LastLogLine      time.Time
+    LogClients       int
+    LastControl      time.Time // last TSBK decoded, zero if none or unknown
+    StateClients     int
+}
+
+type Op25Report struct {
+    Running       bool    `json:"running"`
+    PID           int     `json:"pid,omitempty"`
+    UptimeSeconds float64 `json:"uptime_seconds,omitempty"`
+}
+
+type AudioReport struct {
+    PacketsTotal      uint64   `json:"packets_total"`
+    BytesTotal        uint64   `json:"bytes_total"`
+    PacketRate        float64  `json:"packet_rate"`
+    LastPacketSeconds *float64 `json:"last_packet_age_seconds"`
+    Listeners         int      `json:"listeners"`
+}
+
+type LogReport struct {
+    LinesTotal      uint64   `json:"lines_total"`
+    LastLineSeconds *float64 `json:"last_line_age_seconds"`
+    Listeners       int      `json:"listeners"`
+}
+
+type ControlReport struct {
+    LastTSBKSeconds *float64 `json:"last_tsbk_age_seconds"`
+}
+
+type DiskReport struct {
+    Path       string `json:"path"`
+    FreeBytes  uint64 `json:"free_bytes"`
+    TotalBytes uint64 `json:"total_bytes"`
+    Error      string `json:"error,omitempty"`
+}
+
+type Check struct {
+    Name    string `json:"name"`
+    OK      bool   `json:"ok"`
+    Message string `json:"message,omitempty"`
+}
+
+// Report is the detailed /health document.
+type Report struct {
+    Status         string        `json:"status"`
+    Ready          bool          `json:"ready"`
+    Version        string        `json:"version"`
+    UptimeSeconds  float64       `json:"uptime_seconds"`
+    Op25           Op25Report    `json:"op25"`
+    Audio          AudioReport   `json:"audio"`
+    Logs           LogReport     `json:"logs"`
+    Control        ControlReport `json:"control"`
+    StateListeners int           `json:"state_listeners"`
+    Disk           DiskReport    `json:"disk"`
+    Checks         []Check       `json:"checks"`
+}
+
+// Checker serves the liveness, readiness and detailed health endpoints.
+type Checker struct {
+    gather    func() Sources
+    diskPath  string
+    startTime time.Time
+
+    mu          sync.Mutex
+    thresholds  Thresholds
+    lastPackets uint64
+    lastSample  time.Time
+    packetRate  float64
+}
+
+// NewChecker reports on the state returned by gather and the free space of
+// the filesystem holding diskPath.
+func NewChecker(gather func() Sources, diskPath string, thresholds Thresholds) *Checker {
+    return &Checker{gather: gather, diskPath: diskPath, startTime: time.Now(), thresholds: thresholds}
+}
+
+// SetThresholds changes the readiness thresholds, e.g. on reload.
+func (c *Checker) SetThresholds(thresholds Thresholds) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.thresholds = thresholds
+}
+
+// ServeLive answers as long as the controller process is serving requests.
+func (c *Checker) ServeLive(w http.ResponseWriter, r *http.Request) {
+    if wantsJSON(r) {
+        _ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+        return
+    }
     w.Write([]byte("OK"))
-}
\ No newline at end of file
+}
+
+// ServeReady answers 200 only while OP25 is running and producing output.
+func (c *Checker) ServeReady(w http.ResponseWriter, r *http.Request) {
+    report := c.Report()
+    if !report.Ready {
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }
+    if wantsJSON(r) {
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{"ready": report.Ready, "checks": report.Checks})
+        return
+    }
+    if report.Ready {
+        w.Write([]byte("OK"))
+        return
+    }
+    for _, check := range report.Checks {
+        if !check.OK {
+            fmt.Fprintf(w, "%s: %s\n", check.Name, check.Message)
+        }
+    }
+}
+
+// ServeHealth writes the detailed report as JSON. Clients that don't ask for
+// JSON (Accept: application/json or ?format=json) get the plain "OK" liveness
+// answer older app versions probe for during discovery.
+func (c *Checker) ServeHealth(w http.ResponseWriter, r *http.Request) {
+    if !wantsJSON(r) {
+        w.Write([]byte("OK"))
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    _ = json.NewEncoder(w).Encode(c.Report())
+}
+
+func wantsJSON(r *http.Request) bool {
+    return r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// Report gathers the current state and evaluates the readiness checks.
+func (c *Checker) Report() Report {
+    src := c.gather()
+    now := time.Now()
+
+    report := Report{
+        Status:        "ok",
+        Version:       version.Version,
+        UptimeSeconds: now.Sub(c.startTime).Seconds(),
+        Op25:          Op25Report{Running: src.Op25Running},
+        Audio: AudioReport{
+            PacketsTotal:      src.AudioPackets,
+            BytesTotal:        src.AudioBytes,
+            PacketRate:        c.sampleRate(src.AudioPackets, now),
+            LastPacketSeconds: age(src.LastAudio, now),
+            Listeners:         src.AudioClients,
+        },
+        Logs: LogReport{
+            LinesTotal:      src.LogLines,
+            LastLineSeconds: age(src.LastLogLine, now),
+            Listeners:       src.LogClients,
+        },
+        Control:        ControlReport{LastTSBKSeconds: age(src.LastControl, now)},
+        StateListeners: src.StateClients,
+        Disk:           diskUsage(c.diskPath),
+    }
+    if src.Op25Running {
+        report.Op25.PID = src.Op25PID
+        report.Op25.UptimeSeconds = now.Sub(src.Op25StartedAt).Seconds()
+    }
+
+    op25Check := Check{Name: "op25", OK: src.Op25Running}
+    if !src.Op25Running {
+        op25Check.Message = "OP25 not running"
+    }
+    c.mu.Lock()
+    thresholds := c.thresholds
+    c.mu.Unlock()
+    lastActivity := src.LastLogLine
+    if src.LastControl.After(lastActivity) {
+        lastActivity = src.LastControl
+    }
+    report.Checks = append(report.Checks, op25Check,
+        freshness("audio", src.LastAudio, src.Op25StartedAt, thresholds.Audio, now),
+        freshness("activity", lastActivity, src.Op25StartedAt, thresholds.Activity, now))
+
+    report.Ready = true
+    for _, check := range report.Checks {
+        if !check.OK {
+            report.Ready = false
+            report.Status = "degraded"
+        }
+    }
+    return report
+}
+
+// freshness passes if last is within maxAge, or OP25 started less than
+// maxAge ago and simply hasn't produced anything yet.
+func freshness(name string, last, started time.Time, maxAge time.Duration, now time.Time) Check {
+    check := Check{Name: name, OK: true}
+    switch {
+    case started.IsZero():
+        check.OK = false
+        check.Message = "OP25 not running"
+    case now.Sub(last) <= maxAge:
+    case now.Sub(started) <= maxAge:
+        check.Message = "waiting for first data since OP25 start"
+    case last.IsZero():
+        check.OK = false
+        check.Message = fmt.Sprintf("nothing received since OP25 started %s ago", now.Sub(started).Round(time.Second))
+    default:
+        check.OK = false
+        check.Message = fmt.Sprintf("nothing received for %s", now.Sub(last).Round(time.Second))
+    }
+    return check
+}
+
+// sampleRate returns packets per second since the previous report.
+func (c *Checker) sampleRate(packets uint64, now time.Time) float64 {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    switch {
+    case c.lastSample.IsZero() || packets < c.lastPackets:
+        // First report, or the counter restarted with OP25
+        c.packetRate = 0
+    case now.Sub(c.lastSample) < time.Second:
+        // Too short an interval for a meaningful rate
+        return c.packetRate
+    default:
+        c.packetRate = float64(packets-c.lastPackets) / now.Sub(c.lastSample).Seconds()
+    }
+    c.lastPackets = packets
+    c.lastSample = now
+    return c.packetRate
+}
+
+func age(t, now time.Time) *float64 {
+    if t.IsZero() {
+        return nil
+    }
+    seconds := now.Sub(t).Seconds()
+    return &seconds
+}
+
+func diskUsage(path string) DiskReport {
+    report := DiskReport{Path: path}
+    var fs syscall.Statfs_t
+    if err := syscall.Statfs(path, &fs); err != nil {
+        report.Error = err.Error()
+        return report
+    }
+    report.FreeBytes = fs.Bavail * uint64(fs.Bsize)
+    report.TotalBytes = fs.Blocks * uint64(fs.Bsize)
+    return report
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+
+    "controller25/config"
+    "controller25/importer"
+    "controller25/mdns"
+    "controller25/receiver"
+)
+
+// HPDB exports of a whole state run to tens of megabytes
+const maxImportSize = 64 << 20
+
+func registerImportHandlers(mdnsService *mdns.Service) {
+    // Parses the uploaded files and shows what a commit would write, without
+    // touching any file.
+    handleReceiver("/api/import/preview", "/api/receivers/{id}/import/preview", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        result, status, err := parseImport(w, r)
+        if err != nil {
+            w.WriteHeader(status)
+            _ = json.NewEncoder(w).Encode(ImportPreviewResponse{Error: err.Error()})
+            return
+        }
+        resp := ImportPreviewResponse{Format: result.Format, Warnings: result.Warnings}
+        for _, sys := range result.Systems {
+            s := ImportSystem{
+                SysName:        sys.Trunk.SysName,
+                ControlChannel: sys.Trunk.ControlChannel,
+                NAC:            sys.Trunk.NAC,
+                Modulation:     sys.Trunk.Modulation,
+                TagsFile:       sys.Trunk.TagsFile,
+                Talkgroups:     []ImportTalkgroup{},
+            }
+            for _, tg := range sys.Talkgroups {
+                s.Talkgroups = append(s.Talkgroups, ImportTalkgroup{TGID: tg.TGID, Tag: tg.Tag, Priority: tg.Priority})
+            }
+            resp.Systems = append(resp.Systems, s)
+        }
+        _ = json.NewEncoder(w).Encode(resp)
+    })
+
+    // Writes one previewed system: its tags file and the receiver's trunk
+    // row. A system without control channels only replaces the tags file of
+    // the existing row.
+    handleReceiver("/api/import/commit", "/api/receivers/{id}/import/commit", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        result, status, err := parseImport(w, r)
+        if err != nil {
+            w.WriteHeader(status)
+            _ = json.NewEncoder(w).Encode(ImportCommitResponse{Error: err.Error()})
+            return
+        }
+        index := result.System
+        if index < 0 || index >= len(result.Systems) {
+            w.WriteHeader(http.StatusBadRequest)
+            _ = json.NewEncoder(w).Encode(ImportCommitResponse{Error: fmt.Sprintf("system %d not found, the import has %d", index, len(result.Systems))})
+            return
+        }
+        sys := result.Systems[index]
+
+        trunk := sys.Trunk
+        if trunk.ControlChannel == "" {
+            existing, err := config.ReadTrunkSystem(rx.Profile.TrunkFile)
+            if err != nil {
+                w.WriteHeader(http.StatusConflict)
+                _ = json.NewEncoder(w).Encode(ImportCommitResponse{Error: "the import has no control channels and " + rx.Profile.TrunkFile + " has no system: " + err.Error()})
+                return
+            }
+            existing.TagsFile = trunk.TagsFile
+            trunk = *existing
+        }
+        if len(sys.Talkgroups) > 0 {
+            if err := config.WriteTags(currentConfig.Load().DataPath(sys.Trunk.TagsFile), sys.Talkgroups); err != nil {
+                _ = json.NewEncoder(w).Encode(ImportCommitResponse{Error: err.Error()})
+                return
+            }
+        }
+        if err := config.WriteTrunkSystem(rx.Profile.TrunkFile, &trunk); err != nil {
+            _ = json.NewEncoder(w).Encode(ImportCommitResponse{Error: err.Error()})
+            return
+        }
+        if rx.ID == config.DefaultReceiver {
+            mdnsService.Set(map[string]string{"sys": trunk.SysName})
+        }
+        log.Printf("Imported %s system %q into %s for receiver %s: %d talkgroups",
+            result.Format, trunk.SysName, rx.Profile.TrunkFile, rx.ID, len(sys.Talkgroups))
+
+        _ = json.NewEncoder(w).Encode(ImportCommitResponse{
+            Success:        true,
+            SysName:        trunk.SysName,
+            ControlChannel: trunk.ControlChannel,
+            TagsFile:       trunk.TagsFile,
+            Talkgroups:     len(sys.Talkgroups),
+            Warnings:       result.Warnings,
+        })
+    })
+}
+
+type importResult struct {
+    *importer.Result
+    System int
+}
+
+// parseImport decodes an ImportRequest and parses its files, returning the
+// status to answer with on error.
+func parseImport(w http.ResponseWriter, r *http.Request) (*importResult, int, error) {
+    var req ImportRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportSize)).Decode(&req); err != nil {
+        return nil, http.StatusBadRequest, fmt.Errorf("Invalid request body")
+    }
+    files := make([]importer.File, len(req.Files))
+    for i, f := range req.Files {
+        files[i] = importer.File{Name: f.Name, Data: []byte(f.Data)}
+    }
+    result, err := importer.Parse(req.Format, files, importer.Options{
+        IncludeEncrypted: req.IncludeEncrypted,
+        SysName:          req.SysName,
+    })
+    if err != nil {
+        return nil, http.StatusUnprocessableEntity, err
+    }
+    return &importResult{Result: result, System: req.System}, 0, nil
+}
@@ -0,0 +1,181 @@
+package importer
+
+import (
+    "bytes"
+    "encoding/csv"
+    "fmt"
+    "strconv"
+    "strings"
+
+    "controller25/config"
+)
+
+// readCSV reads every record, allowing rows of differing lengths.
+func readCSV(data []byte) ([][]string, error) {
+    r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+    r.FieldsPerRecord = -1
+    r.TrimLeadingSpace = true
+    return r.ReadAll()
+}
+
+// columns maps lower-cased header names to their index.
+func columns(header []string) map[string]int {
+    cols := make(map[string]int)
+    for i, name := range header {
+        cols[strings.ToLower(strings.TrimSpace(name))] = i
+    }
+    return cols
+}
+
+func field(record []string, i int) string {
+    if i < 0 || i >= len(record) {
+        return ""
+    }
+    return strings.TrimSpace(record[i])
+}
+
+// isSiteCSV tells RadioReference's site export from a talkgroup list.
+func isSiteCSV(records [][]string) bool {
+    if len(records) == 0 {
+        return false
+    }
+    _, ok := columns(records[0])["frequencies"]
+    return ok
+}
+
+// parseTalkgroupCSV reads the talkgroup list RadioReference exports and
+// trunk-recorder reads: Decimal, Hex, Alpha Tag, Mode, Description, Tag,
+// Category[, Priority]. Files without a header use trunk-recorder's older
+// Decimal, Hex, Mode, Alpha Tag, Description, Tag, Group, Priority order.
+func parseTalkgroupCSV(result *Result, name string, data []byte, opts Options) ([]config.Talkgroup, error) {
+    records, err := readCSV(data)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", name, err)
+    }
+    dec, alpha, mode, descr, priority := 0, 3, 2, 4, 7
+    if len(records) > 0 {
+        if _, err := strconv.Atoi(field(records[0], 0)); err != nil {
+            cols := columns(records[0])
+            lookup := func(names ...string) int {
+                for _, n := range names {
+                    if i, ok := cols[n]; ok {
+                        return i
+                    }
+                }
+                return -1
+            }
+            dec = lookup("decimal", "dec", "tgid")
+            alpha = lookup("alpha tag", "alpha", "alphatag")
+            mode = lookup("mode")
+            descr = lookup("description")
+            priority = lookup("priority")
+            records = records[1:]
+            if dec < 0 {
+                return nil, fmt.Errorf("%s: no Decimal column", name)
+            }
+        }
+    }
+
+    tags := []config.Talkgroup{}
+    encrypted := 0
+    for n, record := range records {
+        tgid, err := strconv.Atoi(field(record, dec))
+        if err != nil || tgid <= 0 {
+            if strings.Join(record, "") != "" {
+                result.warnf("%s: line %d: invalid talkgroup %q", name, n+2, field(record, dec))
+            }
+            continue
+        }
+        // Modes are A(nalog), D(igital), T(DMA) and M(ixed), with an E when
+        // encrypted
+        if strings.Contains(strings.ToUpper(field(record, mode)), "E") && !opts.IncludeEncrypted {
+            encrypted++
+            continue
+        }
+        tag := field(record, alpha)
+        if tag == "" {
+            tag = field(record, descr)
+        }
+        p, _ := strconv.Atoi(field(record, priority))
+        tags = append(tags, config.Talkgroup{TGID: tgid, Tag: tag, Priority: p})
+    }
+    if encrypted > 0 {
+        result.warnf("%s: skipped %d encrypted talkgroups", name, encrypted)
+    }
+    return tags, nil
+}
+
+// parseRRSites reads RadioReference's site export: RFSS, Site Dec, Site Hex,
+// Site NAC, Description, ..., Frequencies, followed by one frequency per
+// column with control channels suffixed "c" (alternates "a").
+func parseRRSites(result *Result, name string, records [][]string) []config.TrunkSystem {
+    cols := columns(records[0])
+    freqs := cols["frequencies"]
+    nac, hasNAC := cols["site nac"]
+    descrCol, ok := cols["description"]
+    if !ok {
+        descrCol = -1
+    }
+    var sites []config.TrunkSystem
+    for _, record := range records[1:] {
+        var primary, alternate []string
+        for _, value := range record[min(freqs, len(record)):] {
+            value = strings.ToLower(strings.TrimSpace(value))
+            switch {
+            case strings.HasSuffix(value, "c"):
+                if f, ok := mhz(strings.TrimSuffix(value, "c")); ok {
+                    primary = append(primary, f)
+                }
+            case strings.HasSuffix(value, "a"):
+                if f, ok := mhz(strings.TrimSuffix(value, "a")); ok {
+                    alternate = append(alternate, f)
+                }
+            }
+        }
+        descr := field(record, descrCol)
+        if len(primary)+len(alternate) == 0 {
+            result.warnf("%s: site %q lists no control channels", name, descr)
+            continue
+        }
+        site := config.TrunkSystem{
+            SysName:        descr,
+            ControlChannel: strings.Join(append(primary, alternate...), ","),
+        }
+        if v := field(record, nac); hasNAC && v != "" {
+            site.NAC = "0x" + strings.ToLower(strings.TrimPrefix(v, "0x"))
+        }
+        sites = append(sites, site)
+    }
+    return sites
+}
+
+// parseRadioReference combines a talkgroup CSV with an optional site CSV;
+// each site becomes a system sharing the talkgroups.
+func parseRadioReference(result *Result, files []File, opts Options) error {
+    var sites []config.TrunkSystem
+    var talkgroups []config.Talkgroup
+    for _, f := range files {
+        records, err := readCSV(f.Data)
+        if err != nil {
+            return fmt.Errorf("%s: %v", f.Name, err)
+        }
+        if isSiteCSV(records) {
+            sites = append(sites, parseRRSites(result, f.Name, records)...)
+            continue
+        }
+        tags, err := parseTalkgroupCSV(result, f.Name, f.Data, opts)
+        if err != nil {
+            return err
+        }
+        talkgroups = append(talkgroups, tags...)
+    }
+    if len(sites) == 0 {
+        // Talkgroups only: the commit keeps the existing control channels
+        result.Systems = append(result.Systems, System{Talkgroups: talkgroups})
+        return nil
+    }
+    for _, site := range sites {
+        result.Systems = append(result.Systems, System{Trunk: site, Talkgroups: talkgroups})
+    }
+    return nil
+}
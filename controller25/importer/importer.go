@@ -0,0 +1,187 @@
+package importer
+
+import (
+    "fmt"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+
+    "controller25/config"
+)
+
+// Formats Parse understands.
+const (
+    FormatRadioReference = "radioreference" // RadioReference talkgroup and site CSV exports
+    FormatSDRTrunk       = "sdrtrunk"       // SDRTrunk playlist XML
+    FormatUniden         = "uniden"         // Sentinel favorites or HPDB .hpd files
+    FormatTrunkRecorder  = "trunk-recorder" // config.json and talkgroup CSVs
+)
+
+// File is one uploaded file. Name is only used to detect the format and to
+// resolve references between files, such as trunk-recorder's talkgroupsFile.
+type File struct {
+    Name string
+    Data []byte
+}
+
+type Options struct {
+    IncludeEncrypted bool   // keep talkgroups marked encrypted
+    SysName          string // overrides the system name, e.g. for RadioReference CSVs that lack one
+}
+
+// System is an imported system: its trunk.tsv row and the talkgroups for its
+// tags file. Trunk.TagsFile is the file name the tags would be written to.
+// An empty ControlChannel means only talkgroups were imported.
+type System struct {
+    Trunk      config.TrunkSystem
+    Talkgroups []config.Talkgroup
+}
+
+// Result is what Parse found. Warnings list what was skipped or guessed.
+type Result struct {
+    Format   string
+    Systems  []System
+    Warnings []string
+}
+
+func (r *Result) warnf(format string, args ...any) {
+    r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Parse reads files in the given format, or the one Detect picks when format
+// is empty.
+func Parse(format string, files []File, opts Options) (*Result, error) {
+    if len(files) == 0 {
+        return nil, fmt.Errorf("no files")
+    }
+    if format == "" {
+        format = Detect(files)
+        if format == "" {
+            return nil, fmt.Errorf("unrecognized file format")
+        }
+    }
+    result := &Result{Format: format}
+    var err error
+    switch format {
+    case FormatRadioReference:
+        err = parseRadioReference(result, files, opts)
+    case FormatSDRTrunk:
+        err = parseSDRTrunk(result, files, opts)
+    case FormatUniden:
+        err = parseUniden(result, files, opts)
+    case FormatTrunkRecorder:
+        err = parseTrunkRecorder(result, files, opts)
+    default:
+        return nil, fmt.Errorf("unknown format %q", format)
+    }
+    if err != nil {
+        return nil, err
+    }
+    systems := result.Systems[:0]
+    for _, sys := range result.Systems {
+        if sys.Trunk.ControlChannel != "" || len(sys.Talkgroups) > 0 {
+            systems = append(systems, sys)
+        }
+    }
+    result.Systems = systems
+    if len(result.Systems) == 0 {
+        return nil, fmt.Errorf("no systems or talkgroups found")
+    }
+    for i := range result.Systems {
+        sys := &result.Systems[i]
+        if opts.SysName != "" {
+            sys.Trunk.SysName = opts.SysName
+        }
+        if sys.Trunk.SysName == "" {
+            sys.Trunk.SysName = "Imported"
+        }
+        if len(sys.Talkgroups) > 0 {
+            sys.Trunk.TagsFile = TagsFileName(sys.Trunk.SysName)
+        }
+        sort.SliceStable(sys.Talkgroups, func(a, b int) bool {
+            return sys.Talkgroups[a].TGID < sys.Talkgroups[b].TGID
+        })
+    }
+    return result, nil
+}
+
+// Detect guesses the format from file names and contents, or returns "".
+func Detect(files []File) string {
+    for _, f := range files {
+        ext := strings.ToLower(path.Ext(f.Name))
+        head := strings.TrimSpace(string(f.Data[:min(len(f.Data), 4096)]))
+        switch {
+        case strings.Contains(head, "<playlist"):
+            return FormatSDRTrunk
+        case ext == ".hpd" || strings.HasPrefix(head, "TargetModel") || strings.HasPrefix(head, "Trunk\t"):
+            return FormatUniden
+        case ext == ".json" || strings.HasPrefix(head, "{"):
+            return FormatTrunkRecorder
+        }
+    }
+    for _, f := range files {
+        // RadioReference names its columns "Decimal", "Alpha Tag", ...;
+        // trunk-recorder's older CSVs have no header
+        if strings.HasSuffix(strings.ToLower(f.Name), ".csv") {
+            if strings.Contains(string(f.Data[:min(len(f.Data), 512)]), "Alpha Tag") {
+                return FormatRadioReference
+            }
+            return FormatTrunkRecorder
+        }
+    }
+    return ""
+}
+
+// TagsFileName derives a tags file name from a system name, e.g.
+// "Test County P25" becomes "test-county-p25-tags.tsv".
+func TagsFileName(sysName string) string {
+    var b strings.Builder
+    dash := false
+    for _, r := range strings.ToLower(sysName) {
+        if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+            b.WriteRune(r)
+            dash = false
+        } else if !dash && b.Len() > 0 {
+            b.WriteByte('-')
+            dash = true
+        }
+    }
+    name := strings.TrimSuffix(b.String(), "-")
+    if name == "" {
+        name = "imported"
+    }
+    return name + "-tags.tsv"
+}
+
+// Modulation maps the names other tools use to rx.py's cqpsk or c4fm.
+func Modulation(name string) string {
+    switch strings.ToLower(name) {
+    case "cqpsk", "qpsk", "lsm", "simulcast":
+        return "cqpsk"
+    case "c4fm", "fsk4":
+        return "c4fm"
+    }
+    return ""
+}
+
+// mhz formats a frequency given in Hz, or already in MHz, as trunk.tsv's MHz.
+func mhz(value string) (string, bool) {
+    f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+    if err != nil || f <= 0 {
+        return "", false
+    }
+    if f > 1e5 {
+        f /= 1e6
+    }
+    return strconv.FormatFloat(f, 'f', -1, 64), true
+}
+
+func findFile(files []File, name string) (File, bool) {
+    for _, f := range files {
+        if path.Base(f.Name) == path.Base(name) {
+            return f, true
+        }
+    }
+    return File{}, false
+}
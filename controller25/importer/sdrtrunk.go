@@ -0,0 +1,133 @@
+package importer
+
+import (
+    "encoding/xml"
+    "fmt"
+    "strconv"
+    "strings"
+
+    "controller25/config"
+)
+
+// SDRTrunk playlist XML: aliases carry the talkgroups, grouped into alias
+// lists, and each P25 channel names the alias list it uses.
+type sdrtrunkPlaylist struct {
+    Aliases  []sdrtrunkAlias   `xml:"alias"`
+    Channels []sdrtrunkChannel `xml:"channel"`
+}
+
+type sdrtrunkAlias struct {
+    Name string       `xml:"name,attr"`
+    List string       `xml:"list,attr"`
+    IDs  []sdrtrunkID `xml:"id"`
+}
+
+type sdrtrunkID struct {
+    Type     string `xml:"type,attr"`
+    Value    string `xml:"value,attr"`
+    Protocol string `xml:"protocol,attr"`
+    Priority string `xml:"priority,attr"`
+}
+
+type sdrtrunkChannel struct {
+    Name      string `xml:"name,attr"`
+    System    string `xml:"system,attr"`
+    Site      string `xml:"site,attr"`
+    AliasList string `xml:"alias_list_name"`
+    Source    struct {
+        Type        string   `xml:"type,attr"`
+        Frequency   string   `xml:"frequency,attr"`
+        Frequencies []string `xml:"frequency"` // sourceConfigTunerMultipleFrequency
+    } `xml:"source_configuration"`
+    Decoder struct {
+        Type       string `xml:"type,attr"`
+        Modulation string `xml:"modulation,attr"`
+    } `xml:"decode_configuration"`
+}
+
+func parseSDRTrunk(result *Result, files []File, opts Options) error {
+    var playlist sdrtrunkPlaylist
+    for _, f := range files {
+        var p sdrtrunkPlaylist
+        if err := xml.Unmarshal(f.Data, &p); err != nil {
+            return fmt.Errorf("%s: %v", f.Name, err)
+        }
+        playlist.Aliases = append(playlist.Aliases, p.Aliases...)
+        playlist.Channels = append(playlist.Channels, p.Channels...)
+    }
+
+    tagsByList := make(map[string][]config.Talkgroup)
+    var all []config.Talkgroup
+    ranges := 0
+    for _, alias := range playlist.Aliases {
+        priority := 0
+        for _, id := range alias.IDs {
+            // -1 is "do not monitor", which tags files can't express
+            if p, err := strconv.Atoi(id.Priority); id.Type == "priority" && err == nil && p > 0 {
+                priority = p
+            }
+        }
+        for _, id := range alias.IDs {
+            switch {
+            case id.Type == "talkgroupRange":
+                ranges++
+            case id.Type == "talkgroup" && (id.Protocol == "" || id.Protocol == "APCO25"):
+                tgid, err := strconv.Atoi(id.Value)
+                if err != nil || tgid <= 0 {
+                    result.warnf("alias %q: invalid talkgroup %q", alias.Name, id.Value)
+                    continue
+                }
+                tg := config.Talkgroup{TGID: tgid, Tag: alias.Name, Priority: priority}
+                tagsByList[alias.List] = append(tagsByList[alias.List], tg)
+                all = append(all, tg)
+            }
+        }
+    }
+    if ranges > 0 {
+        result.warnf("skipped %d talkgroup ranges", ranges)
+    }
+
+    skipped := 0
+    for _, ch := range playlist.Channels {
+        if !strings.Contains(ch.Decoder.Type, "P25") {
+            skipped++
+            continue
+        }
+        var freqs []string
+        for _, value := range append([]string{ch.Source.Frequency}, ch.Source.Frequencies...) {
+            if f, ok := mhz(value); ok {
+                freqs = append(freqs, f)
+            }
+        }
+        if len(freqs) == 0 {
+            result.warnf("channel %q has no frequency", ch.Name)
+            continue
+        }
+        name := ch.System
+        if name == "" {
+            name = ch.Name
+        }
+        if ch.Site != "" && ch.Site != name {
+            name += " " + ch.Site
+        }
+        tags := all
+        if ch.AliasList != "" {
+            tags = tagsByList[ch.AliasList]
+        }
+        result.Systems = append(result.Systems, System{
+            Trunk: config.TrunkSystem{
+                SysName:        name,
+                ControlChannel: strings.Join(freqs, ","),
+                Modulation:     Modulation(ch.Decoder.Modulation),
+            },
+            Talkgroups: tags,
+        })
+    }
+    if skipped > 0 {
+        result.warnf("skipped %d channels that aren't P25", skipped)
+    }
+    if len(result.Systems) == 0 {
+        result.Systems = append(result.Systems, System{Talkgroups: all})
+    }
+    return nil
+}
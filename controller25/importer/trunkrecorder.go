@@ -0,0 +1,75 @@
+package importer
+
+import (
+    "encoding/json"
+    "fmt"
+    "path"
+    "strings"
+)
+
+// trunk-recorder's config.json, reduced to what trunk.tsv can use.
+type trunkRecorderConfig struct {
+    Systems []struct {
+        ShortName       string    `json:"shortName"`
+        Type            string    `json:"type"`
+        ControlChannels []float64 `json:"control_channels"`
+        Modulation      string    `json:"modulation"`
+        TalkgroupsFile  string    `json:"talkgroupsFile"`
+    } `json:"systems"`
+}
+
+// parseTrunkRecorder reads config.json systems and the talkgroup CSVs they
+// reference, which have to be uploaded with it. A talkgroup CSV on its own
+// imports just the talkgroups.
+func parseTrunkRecorder(result *Result, files []File, opts Options) error {
+    used := make(map[string]bool)
+    for _, f := range files {
+        if strings.ToLower(path.Ext(f.Name)) != ".json" {
+            continue
+        }
+        var cfg trunkRecorderConfig
+        if err := json.Unmarshal(f.Data, &cfg); err != nil {
+            return fmt.Errorf("%s: %v", f.Name, err)
+        }
+        for _, s := range cfg.Systems {
+            if s.Type != "p25" {
+                result.warnf("%s: skipped %s system %q", f.Name, s.Type, s.ShortName)
+                continue
+            }
+            var freqs []string
+            for _, hz := range s.ControlChannels {
+                if f, ok := mhz(fmt.Sprint(int64(hz))); ok {
+                    freqs = append(freqs, f)
+                }
+            }
+            sys := System{}
+            sys.Trunk.SysName = s.ShortName
+            sys.Trunk.ControlChannel = strings.Join(freqs, ",")
+            sys.Trunk.Modulation = Modulation(s.Modulation)
+            if s.TalkgroupsFile != "" {
+                if tf, ok := findFile(files, s.TalkgroupsFile); ok {
+                    tags, err := parseTalkgroupCSV(result, tf.Name, tf.Data, opts)
+                    if err != nil {
+                        return err
+                    }
+                    sys.Talkgroups = tags
+                    used[tf.Name] = true
+                } else {
+                    result.warnf("%s: talkgroupsFile %s of %q was not uploaded", f.Name, s.TalkgroupsFile, s.ShortName)
+                }
+            }
+            result.Systems = append(result.Systems, sys)
+        }
+    }
+    for _, f := range files {
+        if used[f.Name] || strings.ToLower(path.Ext(f.Name)) == ".json" {
+            continue
+        }
+        tags, err := parseTalkgroupCSV(result, f.Name, f.Data, opts)
+        if err != nil {
+            return err
+        }
+        result.Systems = append(result.Systems, System{Talkgroups: tags})
+    }
+    return nil
+}
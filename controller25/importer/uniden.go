@@ -0,0 +1,111 @@
+package importer
+
+import (
+    "bufio"
+    "bytes"
+    "regexp"
+    "strconv"
+    "strings"
+
+    "controller25/config"
+)
+
+// "SiteId=12": the record and parent IDs that lead each Uniden line. Sentinel
+// favorites and the HPDB differ in which IDs they carry, not in the fields
+// that follow.
+var unidenID = regexp.MustCompile(`^\w+=`)
+
+// parseUniden reads the tab separated Trunk, Site, T-Freq and TGID records of
+// Sentinel favorites lists and HPDB files. Every site of a P25 system
+// becomes a system with the system's talkgroups.
+func parseUniden(result *Result, files []File, opts Options) error {
+    for _, f := range files {
+        var (
+            sysName    string
+            p25        bool
+            talkgroups []config.Talkgroup
+            sites      []config.TrunkSystem
+            skipped    int
+            encrypted  int
+        )
+        flush := func() {
+            for _, site := range sites {
+                result.Systems = append(result.Systems, System{Trunk: site, Talkgroups: talkgroups})
+            }
+            if len(sites) == 0 && len(talkgroups) > 0 {
+                result.Systems = append(result.Systems, System{Trunk: config.TrunkSystem{SysName: sysName}, Talkgroups: talkgroups})
+            }
+            sysName, talkgroups, sites = "", nil, nil
+        }
+
+        scanner := bufio.NewScanner(bytes.NewReader(f.Data))
+        scanner.Buffer(nil, 1<<20)
+        for scanner.Scan() {
+            record := strings.Split(strings.TrimRight(scanner.Text(), "\r"), "\t")
+            fields := record[1:]
+            for len(fields) > 0 && unidenID.MatchString(fields[0]) {
+                fields = fields[1:]
+            }
+            switch record[0] {
+            case "Trunk":
+                flush()
+                // Name, Avoid, Reserve or Date, Type, ...
+                sysName = field(fields, 0)
+                p25 = strings.HasPrefix(field(fields, 3), "P25")
+                if !p25 {
+                    skipped++
+                }
+            case "Conventional":
+                flush()
+                p25 = false
+            case "Site":
+                if p25 {
+                    name := sysName
+                    if site := field(fields, 0); site != "" {
+                        name += " " + site
+                    }
+                    sites = append(sites, config.TrunkSystem{SysName: name})
+                }
+            case "T-Freq":
+                if p25 && len(sites) > 0 {
+                    site := &sites[len(sites)-1]
+                    for _, value := range fields {
+                        if hz, err := strconv.Atoi(value); err == nil && hz >= 25000000 {
+                            f, _ := mhz(value)
+                            site.ControlChannel = strings.Trim(site.ControlChannel+","+f, ",")
+                            break
+                        }
+                    }
+                }
+            case "TGID":
+                // Name, Avoid, TGID, AudioType, ...
+                tgid, err := strconv.Atoi(field(fields, 2))
+                if !p25 || err != nil || tgid <= 0 {
+                    continue
+                }
+                // AudioType is ALL, ANALOG or DIGITAL, or ENCRYPTED where
+                // the database marks the talkgroup as such
+                if strings.HasPrefix(strings.ToUpper(field(fields, 3)), "ENC") && !opts.IncludeEncrypted {
+                    encrypted++
+                    continue
+                }
+                talkgroups = append(talkgroups, config.Talkgroup{TGID: tgid, Tag: field(fields, 0)})
+            }
+        }
+        flush()
+        if err := scanner.Err(); err != nil {
+            return err
+        }
+        if skipped > 0 {
+            result.warnf("%s: skipped %d systems that aren't P25", f.Name, skipped)
+        }
+        if encrypted > 0 {
+            result.warnf("%s: skipped %d encrypted talkgroups", f.Name, encrypted)
+        }
+    }
+    if len(result.Systems) > 0 {
+        // Scanners hunt for the control channel themselves
+        result.warnf("Uniden files don't mark control channels; every site frequency is listed")
+    }
+    return nil
+}
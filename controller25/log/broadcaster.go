@@ -8,6 +8,8 @@ import (
     "net/http"
     "sync"
     "time"
+
+    "controller25/config"
 )
 
 type Broadcaster struct {
@@ -18,15 +20,29 @@ type Broadcaster struct {
     history   []string
     maxLines  int
     startTime time.Time
+    lines     map[string]uint64
+    lastLine  time.Time
+    done      chan struct{}
+    closeOnce sync.Once
+}
+
+// Stats is a snapshot of the broadcaster's counters. Only OP25 output is
+// counted, not the broadcaster's own [system] messages.
+type Stats struct {
+    Lines    map[string]uint64 // by stream, "stdout" or "stderr"
+    LastLine time.Time
+    Clients  int
 }
 
-func NewBroadcaster(stdout, stderr io.Reader) *Broadcaster {
+func NewBroadcaster(stdout, stderr io.Reader, cfg config.LogsConfig) *Broadcaster {
     lb := &Broadcaster{
         clients:   make(map[chan string]struct{}),
         stdout:    stdout,
         stderr:    stderr,
         history:   make([]string, 0),
-        maxLines:  1000,
+        lines:     make(map[string]uint64),
+        done:      make(chan struct{}),
+        maxLines:  cfg.HistoryLines,
         startTime: time.Now(),
     }
     lb.broadcast(fmt.Sprintf("[system] OP25 process starting at %s", lb.startTime.Format(time.RFC3339)))
@@ -37,14 +53,14 @@ func (b *Broadcaster) Start() {
     b.broadcast("[system] Starting log broadcaster")
     b.broadcast("[system] Setting up stdout and stderr pipes")
     if b.stdout != nil {
-        go b.readPipe(b.stdout, "[stdout]")
+        go b.readPipe(b.stdout, "stdout")
     } else {
         msg := "[system] Warning: nil stdout pipe, skipping stdout log streaming"
         log.Print(msg)
         b.broadcast(msg)
     }
     if b.stderr != nil {
-        go b.readPipe(b.stderr, "[stderr]")
+        go b.readPipe(b.stderr, "stderr")
     } else {
         msg := "[system] Warning: nil stderr pipe, skipping stderr log streaming"
         log.Print(msg)
@@ -52,7 +68,8 @@ func (b *Broadcaster) Start() {
     }
 }
 
-func (b *Broadcaster) readPipe(pipe io.Reader, prefix string) {
+func (b *Broadcaster) readPipe(pipe io.Reader, stream string) {
+    prefix := "[" + stream + "]"
     if pipe == nil {
         msg := fmt.Sprintf("[system] Error: readPipe called with nil pipe for %s", prefix)
         log.Print(msg)
@@ -64,6 +81,10 @@ func (b *Broadcaster) readPipe(pipe io.Reader, prefix string) {
     scanner := bufio.NewScanner(pipe)
     for scanner.Scan() {
         line := fmt.Sprintf("%s %s", prefix, scanner.Text())
+        b.mu.Lock()
+        b.lines[stream]++
+        b.lastLine = time.Now()
+        b.mu.Unlock()
         b.broadcast(line)
     }
     if err := scanner.Err(); err != nil {
@@ -71,6 +92,11 @@ func (b *Broadcaster) readPipe(pipe io.Reader, prefix string) {
         log.Print(msg)
         b.broadcast(msg)
     }
+    // The controller reaps OP25 without closing the pipes, so a crash's last
+    // lines are read before the pipe goes away
+    if closer, ok := pipe.(io.Closer); ok {
+        closer.Close()
+    }
     b.broadcast(fmt.Sprintf("[system] %s pipe closed", prefix))
 }
 
@@ -92,6 +118,27 @@ func (b *Broadcaster) broadcast(line string) {
     }
 }
 
+// SetHistoryLines changes how many lines are replayed to new clients,
+// dropping the oldest if needed.
+func (b *Broadcaster) SetHistoryLines(n int) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.maxLines = n
+    if len(b.history) > b.maxLines {
+        b.history = b.history[len(b.history)-b.maxLines:]
+    }
+}
+
+func (b *Broadcaster) Stats() Stats {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    lines := make(map[string]uint64, len(b.lines))
+    for stream, n := range b.lines {
+        lines[stream] = n
+    }
+    return Stats{Lines: lines, LastLine: b.lastLine, Clients: len(b.clients)}
+}
+
 func (b *Broadcaster) ServeSSE(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "text/event-stream")
     w.Header().Set("Cache-Control", "no-cache")
@@ -130,6 +177,18 @@ func (b *Broadcaster) ServeSSE(w http.ResponseWriter, r *http.Request) {
             flusher.Flush()
         case <-notify:
             return
+        case <-b.done:
+            fmt.Fprint(w, "event: shutdown\ndata: [system] Log stream closed\n\n")
+            flusher.Flush()
+            return
         }
     }
+}
+
+// Close ends all SSE streams with a shutdown event. OP25 output keeps being
+// read and logged. It is safe to call more than once.
+func (b *Broadcaster) Close() {
+    b.closeOnce.Do(func() {
+        close(b.done)
+    })
 }
\ No newline at end of file
@@ -1,51 +1,57 @@
 package main
 
 import (
+    "context"
     "encoding/json"
-    "io"
+    "flag"
     "log"
     "net/http"
     "os"
-    "os/exec"
     "os/signal"
     "sync"
     "syscall"
 
-    "controller25/audio"
+    "controller25/audit"
+    "controller25/auth"
+    "controller25/certs"
     "controller25/config"
     "controller25/health"
-    "controller25/log"
     "controller25/mdns"
+    "controller25/multirx"
+    "controller25/radioreference"
+    "controller25/receiver"
+    "controller25/sdr"
+    "controller25/version"
 )
 
-type Op25State struct {
-    cmdObj     *exec.Cmd
-    stdoutPipe io.ReadCloser
-    stderrPipe io.ReadCloser
-    running    bool
-    flags      []string
-    mu         sync.Mutex
-}
-
-var op25 Op25State
-
 // API request/response types
 type Op25StartRequest struct {
     Flags []string `json:"flags"`
 }
 type Op25StartResponse struct {
-    Started bool   `json:"started"`
-    Error   string `json:"error,omitempty"`
+    Started   bool   `json:"started"`
+    StoppedBy string `json:"stopped_by,omitempty"`
+    Error     string `json:"error,omitempty"`
 }
 type Op25StatusResponse struct {
-    Running bool     `json:"running"`
-    Flags   []string `json:"flags"`
+    ID          string   `json:"id"`
+    Description string   `json:"description,omitempty"`
+    Mode        string   `json:"mode"`
+    Running     bool     `json:"running"`
+    Stopping    bool     `json:"stopping,omitempty"`
+    Flags       []string `json:"flags"`
+    // What the running process got, and how that differs from config.ini
+    Resources        *config.EffectiveResources `json:"resources,omitempty"`
+    ResourceWarnings []string                   `json:"resource_warnings,omitempty"`
 }
 
 // Trunk API types
 type TrunkReadResponse struct {
     SysName        string `json:"sysname"`
     ControlChannel string `json:"control_channel"`
+    NAC            string `json:"nac,omitempty"`
+    Modulation     string `json:"modulation,omitempty"`
+    TagsFile       string `json:"tags_file,omitempty"`
     Error          string `json:"error,omitempty"`
 }
 type TrunkWriteRequest struct {
@@ -57,178 +63,317 @@ type TrunkWriteResponse struct {
     Error   string `json:"error,omitempty"`
 }
 
-func stopOp25(audioBroadcaster **audio.Broadcaster, logBroadcaster **logstream.Broadcaster) {
-    if op25.cmdObj != nil && op25.cmdObj.Process != nil {
-        log.Println("Terminating OP25 process...")
-        syscall.Kill(-op25.cmdObj.Process.Pid, syscall.SIGKILL)
-        op25.cmdObj.Wait()
-        log.Println("OP25 process terminated")
-    }
-    op25.running = false
-    op25.flags = nil
-    op25.cmdObj = nil
-    op25.stdoutPipe = nil
-    op25.stderrPipe = nil
-    if *audioBroadcaster != nil {
-        (*audioBroadcaster).Shutdown()
-        *audioBroadcaster = nil
-    }
-    if *logBroadcaster != nil {
-        *logBroadcaster = nil
-    }
+// Conventional channel API types
+type ChannelsResponse struct {
+    Channels []config.Channel `json:"channels"`
+    Error    string           `json:"error,omitempty"`
+}
+type ChannelResponse struct {
+    Success     bool            `json:"success"`
+    Channel     *config.Channel `json:"channel,omitempty"`
+    Op25Restart bool            `json:"op25_restart,omitempty"` // OP25 runs on the old list until restarted
+    Error       string          `json:"error,omitempty"`
 }
 
-func main() {
-    log.Println("Starting controller25 server...")
-    log.Println("Loading configuration...")
+// multi_rx.py configuration API types
+type MultiRxResponse struct {
+    File        string          `json:"file"`
+    Config      *multirx.Config `json:"config,omitempty"`
+    Saved       bool            `json:"saved,omitempty"`
+    Op25Restart bool            `json:"op25_restart,omitempty"` // OP25 runs on the old configuration until restarted
+    Errors      []string        `json:"errors,omitempty"`
+    Error       string          `json:"error,omitempty"`
+}
+type MultiRxValidateResponse struct {
+    Valid  bool     `json:"valid"`
+    Errors []string `json:"errors,omitempty"`
+}
 
-    cfg := config.MustLoadConfig("config.ini")
-    log.Printf("Configuration loaded. OP25 path: %s", cfg.Op25RxPath)
+// RadioReference API types
+type RRImportRequest struct {
+    Username         string `json:"username"`
+    Password         string `json:"password"`
+    SystemID         int    `json:"system_id"`
+    SiteID           int    `json:"site_id"` // may be 0 for single-site systems
+    Modulation       string `json:"modulation"`
+    IncludeEncrypted bool   `json:"include_encrypted"`
+}
+type RRImportResponse struct {
+    Success        bool                  `json:"success"`
+    SysName        string                `json:"sysname,omitempty"`
+    ControlChannel string                `json:"control_channel,omitempty"`
+    NAC            string                `json:"nac,omitempty"`
+    TagsFile       string                `json:"tags_file,omitempty"`
+    Talkgroups     int                   `json:"talkgroups"`
+    Sites          []radioreference.Site `json:"sites,omitempty"` // to choose from when site_id is missing
+    Error          string                `json:"error,omitempty"`
+}
 
-    log.Println("Changing working directory...")
-    config.MustChdir(cfg.Op25RxPath)
-    log.Println("Working directory changed")
+// Import API types. Files are sent as text; every supported format is.
+type ImportFile struct {
+    Name string `json:"name"`
+    Data string `json:"data"`
+}
+type ImportRequest struct {
+    Format           string       `json:"format"` // empty to detect
+    Files            []ImportFile `json:"files"`
+    SysName          string       `json:"sysname"`
+    IncludeEncrypted bool         `json:"include_encrypted"`
+    System           int          `json:"system"` // commit: index into the preview's systems
+}
+type ImportTalkgroup struct {
+    TGID     int    `json:"tgid"`
+    Tag      string `json:"tag"`
+    Priority int    `json:"priority,omitempty"`
+}
+type ImportSystem struct {
+    SysName        string            `json:"sysname"`
+    ControlChannel string            `json:"control_channel"`
+    NAC            string            `json:"nac,omitempty"`
+    Modulation     string            `json:"modulation,omitempty"`
+    TagsFile       string            `json:"tags_file,omitempty"`
+    Talkgroups     []ImportTalkgroup `json:"talkgroups"`
+}
+type ImportPreviewResponse struct {
+    Format   string         `json:"format,omitempty"`
+    Systems  []ImportSystem `json:"systems,omitempty"`
+    Warnings []string       `json:"warnings,omitempty"`
+    Error    string         `json:"error,omitempty"`
+}
+type ImportCommitResponse struct {
+    Success        bool     `json:"success"`
+    SysName        string   `json:"sysname,omitempty"`
+    ControlChannel string   `json:"control_channel,omitempty"`
+    TagsFile       string   `json:"tags_file,omitempty"`
+    Talkgroups     int      `json:"talkgroups"`
+    Warnings       []string `json:"warnings,omitempty"`
+    Error          string   `json:"error,omitempty"`
+}
 
-    // Do NOT auto-start OP25 on first run!
-    // Instead, wait for API request to /api/op25/start
+// Device API types
+type DevicesResponse struct {
+    Devices []sdr.Device `json:"devices"`
+    Errors  []string     `json:"errors,omitempty"`
+}
 
-    // Audio and log broadcasters are initialized when OP25 starts
-    var (
-        audioBroadcaster *audio.Broadcaster
-        logBroadcaster   *logstream.Broadcaster
-    )
+// Config API types
+type ConfigResponse struct {
+    File         string                       `json:"file"`
+    EnvOverrides []string                     `json:"env_overrides"`
+    Values       map[string]map[string]string `json:"values"`
+}
+type ConfigReloadResponse struct {
+    Reloaded bool `json:"reloaded"`
+    *config.Changes
+    Error string `json:"error,omitempty"`
+}
 
-    // Start mDNS Service
-    mdnsShutdown := make(chan struct{})
-    go mdns.StartmDNSService(mdnsShutdown)
+// Backup API types
+type RestoreResponse struct {
+    Restored         bool     `json:"restored"`
+    PreRestoreBackup string   `json:"pre_restore_backup,omitempty"`
+    Files            []string `json:"files,omitempty"`
+    *config.Changes
+    Error string `json:"error,omitempty"`
+}
 
-    // Setup HTTP handlers
-    http.HandleFunc("/audio.wav", func(w http.ResponseWriter, r *http.Request) {
-        if audioBroadcaster == nil {
-            http.Error(w, "Audio not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
-            return
-        }
-        audioBroadcaster.ServeWAV(w, r)
-    })
-    http.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
-        if logBroadcaster == nil {
-            http.Error(w, "Logs not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
-            return
+func main() {
+    configFile := flag.String("config", "config.ini", "path to config.ini")
+    flag.Parse()
+
+    log.Println("Starting controller25 server...")
+    log.Println("Loading configuration...")
+
+    cfg := config.MustLoadConfig(*configFile)
+    log.Printf("Configuration loaded from %s. OP25 path: %s, data dir: %s", cfg.File, cfg.Op25.RxPath, cfg.Server.DataDir)
+    for _, name := range cfg.EnvOverrides {
+        log.Printf("Configuration overridden by %s", name)
+    }
+    currentConfig.Store(cfg)
+    auditLog = audit.NewLog(cfg.Logs.AuditEntries)
+    if os.Geteuid() == 0 {
+        if cfg.Op25.User == "" {
+            log.Println("WARNING: controller25 is running as root and so will OP25, with whatever flags clients send. Set [op25] user in config.ini")
+        } else {
+            log.Printf("WARNING: controller25 is running as root; OP25 runs as %s", cfg.Op25.User)
         }
-        logBroadcaster.ServeSSE(w, r)
-    })
-    http.HandleFunc("/health", health.ServeHealth)
+    }
 
-    http.HandleFunc("/api/op25/start", func(w http.ResponseWriter, r *http.Request) {
-        if r.Method != http.MethodPost {
-            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-            return
+    authenticator := auth.New(cfg.Auth)
+    if authenticator.Enabled() {
+        log.Printf("API authentication enabled (%d tokens, %d users)", len(cfg.Auth.Tokens), len(cfg.Auth.Users))
+    } else {
+        log.Println("WARNING: API authentication disabled, anyone on the network can control OP25. Configure [auth.tokens] in config.ini")
+    }
+
+    // Do NOT auto-start OP25 on first run!
+    // Instead, wait for API request to /api/op25/start or /api/receivers/{id}/start
+    receivers = receiver.NewManager(cfg.Receivers)
+    for _, rx := range receivers.All() {
+        log.Printf("Receiver %s configured (trunk file %s)", rx.ID, rx.Profile.TrunkFile)
+        p, err := cfg.ReceiverProcess(rx.Profile)
+        if err == nil {
+            err = p.Preflight()
         }
-        var req Op25StartRequest
-        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-            http.Error(w, "Invalid request body", http.StatusBadRequest)
-            return
+        if err != nil {
+            log.Printf("WARNING: receiver %s can't start OP25: %v", rx.ID, err)
         }
+    }
 
-        op25.mu.Lock()
-        defer op25.mu.Unlock()
-        // If already running, shut down and restart
-        if op25.running {
-            stopOp25(&audioBroadcaster, &logBroadcaster)
+    // TXT records let the app show controllers without probing each one
+    txt := map[string]string{
+        "version": version.Version,
+        "api":     "/api",
+        "audio":   "wav",
+        "auth":    authMode(authenticator),
+        "tls":     "0",
+        "op25":    "stopped",
+        "sys":     "",
+    }
+    if sys, err := config.ReadTrunkSystem(receivers.Default().Profile.TrunkFile); err == nil {
+        txt["sys"] = sys.SysName
+    }
+    if cfg.TLS.Enabled {
+        if err := certs.EnsureSelfSigned(cfg.TLS.CertFile, cfg.TLS.KeyFile); err != nil {
+            log.Fatalf("Failed to prepare TLS certificate: %v", err)
         }
-
-        // Start OP25 with specified flags
-        op25Cmd, stdoutPipe, stderrPipe, err := config.StartOp25ProcessUDPWithFlags(req.Flags)
+        fingerprint, err := certs.Fingerprint(cfg.TLS.CertFile)
         if err != nil {
-            resp := Op25StartResponse{Started: false, Error: err.Error()}
-            _ = json.NewEncoder(w).Encode(resp)
-            return
+            log.Fatalf("Failed to read TLS certificate: %v", err)
         }
+        log.Printf("TLS certificate SHA-256 fingerprint: %s", fingerprint)
+        // Clients pin this on discovery instead of trusting a CA
+        txt["tls"] = "1"
+        txt["tlsfp"] = "sha256:" + fingerprint
+    }
 
-        op25.cmdObj = op25Cmd
-        op25.stdoutPipe = stdoutPipe
-        op25.stderrPipe = stderrPipe
-        op25.running = true
-        op25.flags = req.Flags
+    // Start mDNS Service, advertising the port we actually listen on
+    mdnsShutdown := make(chan struct{})
+    var mdnsDone sync.WaitGroup
+    mdnsService := mdns.NewService(cfg.MDNS, txt)
+    mdnsDone.Add(1)
+    go func() {
+        defer mdnsDone.Done()
+        mdnsService.Run(mdnsShutdown)
+    }()
 
-        // Start broadcasters
-        audioBroadcaster = audio.NewBroadcaster("127.0.0.1:23456")
-        logBroadcaster = logstream.NewBroadcaster(stdoutPipe, stderrPipe)
-        go audioBroadcaster.Start()
-        go logBroadcaster.Start()
+    // Discover other controllers so any one of them can list the fleet
+    peerBrowser := mdns.NewBrowser(cfg.MDNS, mdnsService)
+    mdnsDone.Add(1)
+    go func() {
+        defer mdnsDone.Done()
+        peerBrowser.Run(mdnsShutdown)
+    }()
 
-        resp := Op25StartResponse{Started: true}
-        _ = json.NewEncoder(w).Encode(resp)
-    })
+    // Setup HTTP handlers
+    registerReceiverHandlers(mdnsService)
+    registerRadioReferenceHandlers(mdnsService)
+    registerImportHandlers(mdnsService)
+    registerChannelHandlers()
+    registerMultiRxHandlers()
+
+    // Health reports on the default receiver, which older apps know about
+    gatherSources := func() health.Sources {
+        return receivers.Default().Sources()
+    }
+    healthChecker = health.NewChecker(gatherSources, cfg.Server.DataDir, healthThresholds(cfg.Health))
+    http.HandleFunc("/health", healthChecker.ServeHealth)
+    http.HandleFunc("/health/live", healthChecker.ServeLive)
+    http.HandleFunc("/health/ready", healthChecker.ServeReady)
+    http.Handle("/metrics", newMetricsRegistry())
 
-    http.HandleFunc("/api/op25/stop", func(w http.ResponseWriter, r *http.Request) {
-        if r.Method != http.MethodPost {
+    http.HandleFunc("/api/peers", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
             http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
             return
         }
-        op25.mu.Lock()
-        defer op25.mu.Unlock()
-        if !op25.running || op25.cmdObj == nil {
-            w.WriteHeader(http.StatusConflict)
-            _ = json.NewEncoder(w).Encode(Op25StartResponse{Started: false, Error: "OP25 not running"})
-            return
-        }
-        stopOp25(&audioBroadcaster, &logBroadcaster)
-        _ = json.NewEncoder(w).Encode(Op25StartResponse{Started: false})
+        _ = json.NewEncoder(w).Encode(peerBrowser.Peers())
     })
 
-    http.HandleFunc("/api/op25/status", func(w http.ResponseWriter, r *http.Request) {
-        op25.mu.Lock()
-        defer op25.mu.Unlock()
-        _ = json.NewEncoder(w).Encode(Op25StatusResponse{
-            Running: op25.running,
-            Flags:   op25.flags,
-        })
-    })
-
-    // Trunk file read endpoint
-    http.HandleFunc("/api/trunk/read", func(w http.ResponseWriter, r *http.Request) {
+    // Attached SDRs and which receiver uses each
+    http.HandleFunc("/api/devices", func(w http.ResponseWriter, r *http.Request) {
         if r.Method != http.MethodGet {
             http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
             return
         }
-        sys, err := config.ReadTrunkSystem(config.TrunkFileName)
-        if err != nil {
-            _ = json.NewEncoder(w).Encode(TrunkReadResponse{Error: err.Error()})
+        var claims []sdr.Claim
+        for _, rx := range receivers.All() {
+            if status := rx.Status(); status.Running {
+                claims = append(claims, sdr.Claim{Receiver: rx.ID, Args: sdr.ArgsFromFlags(status.Flags)})
+            }
+        }
+        devices, errs := sdr.Scan(r.Context(), currentConfig.Load().SDR, claims)
+        resp := DevicesResponse{Devices: devices}
+        for _, err := range errs {
+            resp.Errors = append(resp.Errors, err.Error())
+        }
+        _ = json.NewEncoder(w).Encode(resp)
+    })
+
+    // Effective configuration, secrets redacted
+    http.HandleFunc("/api/config", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
             return
         }
-        _ = json.NewEncoder(w).Encode(TrunkReadResponse{
-            SysName:        sys.SysName,
-            ControlChannel: sys.ControlChannel,
+        cfg := currentConfig.Load()
+        _ = json.NewEncoder(w).Encode(ConfigResponse{
+            File:         cfg.File,
+            EnvOverrides: append([]string{}, cfg.EnvOverrides...),
+            Values:       cfg.Values(),
         })
     })
 
-    // Trunk file write endpoint
-    http.HandleFunc("/api/trunk/write", func(w http.ResponseWriter, r *http.Request) {
+    reload := func() (config.Changes, error) {
+        return reloadConfig(authenticator, mdnsService)
+    }
+    http.HandleFunc("/api/config/reload", func(w http.ResponseWriter, r *http.Request) {
         if r.Method != http.MethodPost {
             http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
             return
         }
-        var req TrunkWriteRequest
-        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-            _ = json.NewEncoder(w).Encode(TrunkWriteResponse{Success: false, Error: "Invalid request body"})
-            return
-        }
-        sys := &config.TrunkSystem{
-            SysName:        req.SysName,
-            ControlChannel: req.ControlChannel,
-        }
-        err := config.WriteTrunkSystem(config.TrunkFileName, sys)
+        changes, err := reload()
         if err != nil {
-            _ = json.NewEncoder(w).Encode(TrunkWriteResponse{Success: false, Error: err.Error()})
+            log.Printf("Config reload failed, keeping the current configuration: %v", err)
+            w.WriteHeader(http.StatusUnprocessableEntity)
+            _ = json.NewEncoder(w).Encode(ConfigReloadResponse{Reloaded: false, Error: err.Error()})
             return
         }
-        _ = json.NewEncoder(w).Encode(TrunkWriteResponse{Success: true})
+        _ = json.NewEncoder(w).Encode(ConfigReloadResponse{Reloaded: true, Changes: &changes})
+    })
+
+    // Backup and restore of config.ini, trunk files and what they reference
+    registerBackupHandlers(reload, mdnsService)
+
+    // Typed rx.py control commands (hold, lockout, tune, capture, ...)
+    registerCommandHandlers()
+
+    handler := httpDurations.InstrumentHandler(http.DefaultServeMux, authenticator.Middleware(http.DefaultServeMux))
+    server := &http.Server{Addr: cfg.Server.Listen, Handler: handler}
+
+    // Audio and SSE streams never go idle on their own, so end them as soon
+    // as Shutdown has stopped accepting connections
+    server.RegisterOnShutdown(func() {
+        log.Println("Closing audio and event streams...")
+        for _, rx := range receivers.All() {
+            rx.CloseStreams()
+        }
     })
 
     // Channel for shutdown
     done := make(chan struct{})
 
+    // SIGHUP reloads config.ini like POST /api/config/reload
+    go func() {
+        hupChan := make(chan os.Signal, 1)
+        signal.Notify(hupChan, syscall.SIGHUP)
+        for range hupChan {
+            if _, err := reload(); err != nil {
+                log.Printf("Config reload failed, keeping the current configuration: %v", err)
+            }
+        }
+    }()
+
     // Goroutine for graceful shutdown
     go func() {
         sigChan := make(chan os.Signal, 1)
@@ -236,22 +381,50 @@ func main() {
         <-sigChan
 
         log.Println("Shutting down...")
-
-        // Shutdown mDNS
-        close(mdnsShutdown)
-
-        // Shutdown audio broadcaster and OP25 process
-        op25.mu.Lock()
-        stopOp25(&audioBroadcaster, &logBroadcaster)
-        op25.mu.Unlock()
-
+        cfg := currentConfig.Load()
+        ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+        defer cancel()
+
+        finished := make(chan struct{})
+        go func() {
+            // Stop accepting connections and drain the active ones
+            if err := server.Shutdown(ctx); err != nil {
+                log.Printf("HTTP connections did not drain: %v", err)
+                server.Close()
+            }
+            log.Println("HTTP server stopped")
+
+            // Stop every OP25 process and its broadcasters
+            receivers.StopAll(cfg.Op25)
+
+            // Shutdown mDNS, waiting for the goodbye announcements
+            close(mdnsShutdown)
+            mdnsDone.Wait()
+            close(finished)
+        }()
+
+        select {
+        case <-finished:
+        case <-ctx.Done():
+            log.Printf("Shutdown did not complete within %s, exiting anyway", cfg.Server.ShutdownTimeout)
+            // Never leave rx.py running without its controller
+            for _, rx := range receivers.All() {
+                rx.Kill()
+            }
+        }
         close(done)
     }()
 
-    log.Println("Starting HTTP server on :9000")
-    server := &http.Server{Addr: ":9000"}
     go func() {
-        if err := server.ListenAndServe(); err != http.ErrServerClosed {
+        var err error
+        if cfg.TLS.Enabled {
+            log.Printf("Starting HTTPS server on %s", cfg.Server.Listen)
+            err = server.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+        } else {
+            log.Printf("Starting HTTP server on %s", cfg.Server.Listen)
+            err = server.ListenAndServe()
+        }
+        if err != http.ErrServerClosed {
             log.Fatalf("HTTP server failed: %v", err)
         }
     }()
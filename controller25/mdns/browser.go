@@ -0,0 +1,249 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+
+	"controller25/config"
+)
+
+const (
+	// Each browse runs this long before being restarted, so peers that
+	// stopped answering are noticed and new ones are found promptly.
+	browseInterval = 30 * time.Second
+	checkInterval  = 15 * time.Second
+	checkTimeout   = 2 * time.Second
+	// Peers not seen for this long are forgotten.
+	peerExpiry = 10 * time.Minute
+)
+
+// Peer is another controller discovered over mDNS.
+type Peer struct {
+	Instance  string            `json:"instance"`
+	Host      string            `json:"host"`
+	Addresses []string          `json:"addresses"`
+	Port      int               `json:"port"`
+	URL       string            `json:"url"`
+	TXT       map[string]string `json:"txt"`
+	FirstSeen time.Time         `json:"first_seen"`
+	LastSeen  time.Time         `json:"last_seen"`
+	Reachable bool              `json:"reachable"`
+	LastCheck time.Time         `json:"last_check,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// Browser discovers other controllers advertising the same service type and
+// tracks whether they are reachable.
+type Browser struct {
+	cfg   config.MDNSConfig
+	self  *Service
+	mu    sync.Mutex
+	peers map[string]*Peer
+}
+
+// NewBrowser creates a browser for cfg.Service. self is excluded from results.
+func NewBrowser(cfg config.MDNSConfig, self *Service) *Browser {
+	return &Browser{
+		cfg:   cfg,
+		self:  self,
+		peers: make(map[string]*Peer),
+	}
+}
+
+// Run browses for peers until shutdown is closed.
+func (b *Browser) Run(shutdown chan struct{}) {
+	var opts []zeroconf.ClientOption
+	if len(b.cfg.Interfaces) > 0 {
+		ifaces, err := net.Interfaces()
+		if err == nil {
+			if selected := selectInterfaces(ifaces, b.cfg.Interfaces); selected != nil {
+				opts = append(opts, zeroconf.SelectIfaces(selected))
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-shutdown
+		cancel()
+	}()
+	go b.checkLoop(ctx)
+
+	log.Printf("mDNS peer browser started for %s", b.cfg.Service)
+	for ctx.Err() == nil {
+		// A resolver can't be reused once its browse context ends
+		resolver, err := zeroconf.NewResolver(opts...)
+		if err != nil {
+			log.Printf("mDNS peer browser: %v", err)
+		} else {
+			b.browse(ctx, resolver)
+		}
+		b.expire()
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+	log.Println("mDNS peer browser stopped.")
+}
+
+func (b *Browser) browse(parent context.Context, resolver *zeroconf.Resolver) {
+	ctx, cancel := context.WithTimeout(parent, browseInterval)
+	defer cancel()
+	entries := make(chan *zeroconf.ServiceEntry)
+	if err := resolver.Browse(ctx, b.cfg.Service, domain, entries); err != nil {
+		log.Printf("mDNS peer browser: %v", err)
+		return
+	}
+	for {
+		select {
+		case entry, ok := <-entries:
+			if !ok {
+				return
+			}
+			if entry != nil {
+				b.seen(entry)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *Browser) seen(entry *zeroconf.ServiceEntry) {
+	if entry.Instance == b.self.Instance() {
+		return
+	}
+	var addrs []string
+	for _, ip := range entry.AddrIPv4 {
+		addrs = append(addrs, ip.String())
+	}
+	for _, ip := range entry.AddrIPv6 {
+		addrs = append(addrs, ip.String())
+	}
+	txt := make(map[string]string, len(entry.Text))
+	for _, record := range entry.Text {
+		k, v, _ := strings.Cut(record, "=")
+		txt[k] = v
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	peer, ok := b.peers[entry.Instance]
+	if !ok {
+		log.Printf("mDNS: discovered peer %s (%s:%d)", entry.Instance, entry.HostName, entry.Port)
+		peer = &Peer{Instance: entry.Instance, FirstSeen: time.Now()}
+		b.peers[entry.Instance] = peer
+	}
+	peer.Host = entry.HostName
+	peer.Port = entry.Port
+	peer.TXT = txt
+	peer.LastSeen = time.Now()
+	if len(addrs) > 0 {
+		peer.Addresses = addrs
+	}
+	peer.URL = peerURL(peer)
+}
+
+func peerURL(peer *Peer) string {
+	if len(peer.Addresses) == 0 {
+		return ""
+	}
+	scheme := "http"
+	if peer.TXT["tls"] == "1" {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(peer.Addresses[0], strconv.Itoa(peer.Port))
+}
+
+func (b *Browser) expire() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name, peer := range b.peers {
+		if time.Since(peer.LastSeen) > peerExpiry {
+			log.Printf("mDNS: peer %s not seen for %s, forgetting it", name, peerExpiry)
+			delete(b.peers, name)
+		}
+	}
+}
+
+// checkLoop periodically dials every peer's advertised port. A TCP connect is
+// enough to tell a live controller from a stale mDNS cache entry and works
+// whether or not the peer requires TLS or authentication.
+func (b *Browser) checkLoop(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		type target struct {
+			instance string
+			addrs    []string
+			port     int
+		}
+		b.mu.Lock()
+		targets := make([]target, 0, len(b.peers))
+		for _, peer := range b.peers {
+			targets = append(targets, target{peer.Instance, peer.Addresses, peer.Port})
+		}
+		b.mu.Unlock()
+
+		for _, t := range targets {
+			err := dialAny(t.addrs, t.port)
+			b.mu.Lock()
+			if peer, ok := b.peers[t.instance]; ok {
+				if peer.Reachable != (err == nil) {
+					log.Printf("mDNS: peer %s reachable=%v", t.instance, err == nil)
+				}
+				peer.Reachable = err == nil
+				peer.LastCheck = time.Now()
+				peer.Error = ""
+				if err != nil {
+					peer.Error = err.Error()
+				}
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+func dialAny(addrs []string, port int) error {
+	err := errors.New("no addresses advertised")
+	for _, addr := range addrs {
+		var conn net.Conn
+		conn, err = net.DialTimeout("tcp", net.JoinHostPort(addr, strconv.Itoa(port)), checkTimeout)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+	}
+	return err
+}
+
+// Peers returns the known peers sorted by instance name.
+func (b *Browser) Peers() []Peer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	peers := make([]Peer, 0, len(b.peers))
+	for _, peer := range b.peers {
+		p := *peer
+		p.Addresses = append([]string{}, peer.Addresses...)
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Instance < peers[j].Instance })
+	return peers
+}
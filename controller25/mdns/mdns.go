@@ -1,18 +1,91 @@
 package mdns
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/grandcat/zeroconf"
+
+	"controller25/config"
 )
 
-func StartmDNSService(shutdown chan struct{}) {
-	instanceName := "OP25MCH" // Fixed instance name
-	serviceType := "_op25mch._tcp"
-	domain := "local."
-	port := 9000
+// TXT record format version. Bump when keys change meaning.
+const txtVersion = "1"
+
+const domain = "local."
+
+// How long to listen for another controller already using our name
+const conflictTimeout = 2 * time.Second
+
+const maxConflictSuffix = 20
+
+// Service advertises the controller over mDNS. Its TXT records describe the
+// controller's current state and can be updated while it is running, so the
+// app can show useful information without probing every controller it finds.
+type Service struct {
+	cfg      config.MDNSConfig
+	mu       sync.Mutex
+	server   *zeroconf.Server
+	instance string
+	txt      map[string]string
+}
+
+// NewService creates a service advertising txt. cfg.Port must already be
+// resolved to the HTTP listen port. Nothing is published until Run.
+func NewService(cfg config.MDNSConfig, txt map[string]string) *Service {
+	s := &Service{cfg: cfg, txt: map[string]string{"txtv": txtVersion}}
+	for k, v := range txt {
+		s.txt[k] = v
+	}
+	return s
+}
+
+// Set updates TXT records and re-announces them if anything changed.
+func (s *Service) Set(txt map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for k, v := range txt {
+		if s.txt[k] != v {
+			s.txt[k] = v
+			changed = true
+		}
+	}
+	if changed && s.server != nil {
+		log.Printf("mDNS TXT records updated: %v", s.records())
+		s.server.SetText(s.records())
+	}
+}
+
+// Instance returns the registered instance name, or "" before registration.
+func (s *Service) Instance() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.instance
+}
+
+// records must be called with s.mu held.
+func (s *Service) records() []string {
+	records := make([]string, 0, len(s.txt))
+	for k, v := range s.txt {
+		records = append(records, k+"="+v)
+	}
+	sort.Strings(records)
+	return records
+}
+
+// Run advertises the controller until shutdown is closed.
+func (s *Service) Run(shutdown chan struct{}) {
+	serviceType := s.cfg.Service
+	port := s.cfg.Port
 
 	// Get all network interfaces
 	ifaces, err := net.Interfaces()
@@ -33,20 +106,40 @@ func StartmDNSService(shutdown chan struct{}) {
 		}
 	}
 
-	// Register service on ALL interfaces (pass nil to zeroconf.Register)
+	// nil means all available interfaces
+	selected := selectInterfaces(ifaces, s.cfg.Interfaces)
+	ifaceDesc := "all interfaces"
+	if selected != nil {
+		names := make([]string, len(selected))
+		for i, iface := range selected {
+			names[i] = iface.Name
+		}
+		ifaceDesc = strings.Join(names, ", ")
+	}
+
+	instanceName := s.cfg.Instance
+	if instanceName == "" {
+		instanceName = defaultInstanceName(ifaces)
+	}
+	instanceName = uniqueInstanceName(instanceName, serviceType, selected)
+
+	s.mu.Lock()
 	server, err := zeroconf.Register(
 		instanceName,
 		serviceType,
 		domain,
 		port,
-		[]string{"txtv=0", "version=1.0"},
-		nil, // Passing nil means all available interfaces
+		s.records(),
+		selected,
 	)
 	if err != nil {
 		log.Fatalf("Failed to start mDNS service: %v", err)
 	}
+	s.server = server
+	s.instance = instanceName
+	s.mu.Unlock()
 
-	log.Printf("mDNS service registered as %s.%s%s:%d on all interfaces", instanceName, serviceType, domain, port)
+	log.Printf("mDNS service registered as %s.%s.%s:%d on %s", instanceName, serviceType, domain, port, ifaceDesc)
 
 	// Periodically log advertising status
 	go func() {
@@ -55,7 +148,7 @@ func StartmDNSService(shutdown chan struct{}) {
 		for {
 			select {
 			case <-ticker.C:
-				log.Printf("mDNS service actively advertising on all interfaces")
+				log.Printf("mDNS service actively advertising on %s", ifaceDesc)
 			case <-shutdown:
 				return
 			}
@@ -64,6 +157,95 @@ func StartmDNSService(shutdown chan struct{}) {
 
 	<-shutdown
 	log.Println("Shutting down mDNS service...")
+	s.mu.Lock()
+	s.server = nil
+	s.mu.Unlock()
 	server.Shutdown()
 	log.Println("mDNS service shut down.")
-}
\ No newline at end of file
+}
+
+// selectInterfaces returns the interfaces named in names, or nil (all
+// interfaces) if names is empty or none of them exist.
+func selectInterfaces(ifaces []net.Interface, names []string) []net.Interface {
+	if len(names) == 0 {
+		return nil
+	}
+	var selected []net.Interface
+	for _, name := range names {
+		found := false
+		for _, iface := range ifaces {
+			if iface.Name == name {
+				selected = append(selected, iface)
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Printf("mDNS: interface %s not found, ignoring", name)
+		}
+	}
+	if len(selected) == 0 {
+		log.Printf("mDNS: none of the configured interfaces exist, advertising on all interfaces")
+		return nil
+	}
+	return selected
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9-]+`)
+
+// defaultInstanceName derives a name unique to this machine from its hostname
+// and the tail of its first hardware address, since cloned SD cards often
+// share a hostname.
+func defaultInstanceName(ifaces []net.Interface) string {
+	name := "OP25MCH"
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hostname, _, _ = strings.Cut(hostname, ".")
+		name += "-" + strings.Trim(unsafeNameChars.ReplaceAllString(hostname, "-"), "-")
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) < 3 {
+			continue
+		}
+		mac := iface.HardwareAddr
+		return fmt.Sprintf("%s-%02x%02x%02x", name, mac[len(mac)-3], mac[len(mac)-2], mac[len(mac)-1])
+	}
+	return name
+}
+
+// uniqueInstanceName appends -2, -3, ... to name while another controller on
+// the network already answers for it.
+func uniqueInstanceName(name, serviceType string, ifaces []net.Interface) string {
+	resolver, err := zeroconf.NewResolver(zeroconf.SelectIfaces(ifaces))
+	if err != nil {
+		log.Printf("mDNS: cannot check for name conflicts: %v", err)
+		return name
+	}
+	candidate := name
+	for i := 2; i <= maxConflictSuffix; i++ {
+		if !instanceExists(resolver, candidate, serviceType) {
+			if candidate != name {
+				log.Printf("mDNS: instance name %s is taken, using %s", name, candidate)
+			}
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d", name, i)
+	}
+	log.Printf("mDNS: no free suffix for %s, registering anyway", name)
+	return name
+}
+
+func instanceExists(resolver *zeroconf.Resolver, instance, serviceType string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), conflictTimeout)
+	defer cancel()
+	entries := make(chan *zeroconf.ServiceEntry)
+	if err := resolver.Lookup(ctx, instance, serviceType, domain, entries); err != nil {
+		log.Printf("mDNS: lookup of %s failed: %v", instance, err)
+		return false
+	}
+	select {
+	case entry, ok := <-entries:
+		return ok && entry != nil
+	case <-ctx.Done():
+		return false
+	}
+}
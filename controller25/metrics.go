@@ -0,0 +1,129 @@
+package main
+
+import (
+    "sort"
+    "time"
+
+    "controller25/health"
+    "controller25/metrics"
+    "controller25/receiver"
+    "controller25/terminal"
+    "controller25/version"
+)
+
+var httpDurations = metrics.NewHistogramVec("controller25_http_request_duration_seconds",
+    "HTTP request latency by route.", "route", metrics.DefaultBuckets)
+
+// newMetricsRegistry serves the Prometheus metrics, labelled by receiver.
+// Audio and log counters come from the current broadcasters and restart
+// from zero with OP25, which Prometheus treats as an ordinary counter reset.
+func newMetricsRegistry() *metrics.Registry {
+    reg := metrics.NewRegistry()
+    reg.Register(func(e *metrics.Encoder) {
+        e.Gauge("controller25_info", "Controller version.", 1, metrics.Label{Name: "version", Value: version.Version})
+
+        all := receivers.All()
+        sources := make([]health.Sources, len(all))
+        labels := make([]metrics.Label, len(all))
+        for i, rx := range all {
+            sources[i] = rx.Sources()
+            labels[i] = metrics.Label{Name: "receiver", Value: rx.ID}
+        }
+        // Samples of a family must be written back to back
+        each := func(write func(rx *receiver.Receiver, src health.Sources, label metrics.Label)) {
+            for i, rx := range all {
+                write(rx, sources[i], labels[i])
+            }
+        }
+
+        each(func(rx *receiver.Receiver, src health.Sources, label metrics.Label) {
+            e.Counter("controller25_op25_starts_total", "OP25 processes started.", float64(rx.Starts.Value()), label)
+        })
+        each(func(rx *receiver.Receiver, src health.Sources, label metrics.Label) {
+            e.Counter("controller25_op25_crashes_total", "OP25 processes that exited without being stopped.", float64(rx.Crashes.Value()), label)
+        })
+        each(func(rx *receiver.Receiver, src health.Sources, label metrics.Label) {
+            running := 0.0
+            if src.Op25Running {
+                running = 1
+            }
+            e.Gauge("controller25_op25_running", "Whether OP25 is running.", running, label)
+        })
+        each(func(rx *receiver.Receiver, src health.Sources, label metrics.Label) {
+            uptime := 0.0
+            if src.Op25Running {
+                uptime = time.Since(src.Op25StartedAt).Seconds()
+            }
+            e.Gauge("controller25_op25_uptime_seconds", "Seconds since OP25 was started.", uptime, label)
+        })
+
+        each(func(rx *receiver.Receiver, src health.Sources, label metrics.Label) {
+            e.Counter("controller25_audio_udp_packets_total", "UDP audio packets received from OP25.", float64(src.AudioPackets), label)
+        })
+        each(func(rx *receiver.Receiver, src health.Sources, label metrics.Label) {
+            e.Counter("controller25_audio_udp_bytes_total", "UDP audio bytes received from OP25.", float64(src.AudioBytes), label)
+        })
+        each(func(rx *receiver.Receiver, src health.Sources, label metrics.Label) {
+            e.Counter("controller25_audio_odd_length_packets_total", "UDP audio packets truncated to whole samples.", float64(src.AudioOddPackets), label)
+        })
+        each(func(rx *receiver.Receiver, src health.Sources, label metrics.Label) {
+            e.Counter("controller25_audio_dropped_packets_total", "Audio packets dropped for slow listeners.", float64(src.AudioDropped), label)
+        })
+        each(func(rx *receiver.Receiver, src health.Sources, label metrics.Label) {
+            e.Counter("controller25_audio_held_packets_total", "Audio packets from a multi_rx.py channel not streamed while another channel held the stream.", float64(src.AudioHeld), label)
+        })
+        each(func(rx *receiver.Receiver, src health.Sources, label metrics.Label) {
+            e.Gauge("controller25_audio_clients", "Connected audio listeners.", float64(src.AudioClients), label)
+        })
+        each(func(rx *receiver.Receiver, src health.Sources, label metrics.Label) {
+            e.Gauge("controller25_log_sse_clients", "Connected log stream clients.", float64(src.LogClients), label)
+        })
+        each(func(rx *receiver.Receiver, src health.Sources, label metrics.Label) {
+            e.Gauge("controller25_state_sse_clients", "Connected state feed clients.", float64(src.StateClients), label)
+        })
+
+        each(func(rx *receiver.Receiver, src health.Sources, label metrics.Label) {
+            streams := make([]string, 0, len(src.LogLinesByStream))
+            for stream := range src.LogLinesByStream {
+                streams = append(streams, stream)
+            }
+            sort.Strings(streams)
+            for _, stream := range streams {
+                e.Counter("controller25_log_lines_total", "OP25 output lines by stream.",
+                    float64(src.LogLinesByStream[stream]), label, metrics.Label{Name: "stream", Value: stream})
+            }
+        })
+    })
+    reg.Register(httpDurations.Collect)
+    reg.Register(collectDecoderStats)
+    return reg
+}
+
+// collectDecoderStats exports what rx.py reports about decoding, for the
+// receivers whose state poller is running: the TSBK count, from which
+// Prometheus derives the rate. rx.py reports no decode error count, so there
+// is no error rate.
+func collectDecoderStats(e *metrics.Encoder) {
+    type decoder struct {
+        label metrics.Label
+        stats terminal.DecoderStats
+    }
+    var decoders []decoder
+    for _, rx := range receivers.All() {
+        if _, _, poller := rx.Terminal(); poller != nil {
+            decoders = append(decoders, decoder{metrics.Label{Name: "receiver", Value: rx.ID}, poller.DecoderStats()})
+        }
+    }
+
+    for _, d := range decoders {
+        nacs := make([]string, 0, len(d.stats.TSBKs))
+        for nac := range d.stats.TSBKs {
+            nacs = append(nacs, nac)
+        }
+        sort.Strings(nacs)
+        for _, nac := range nacs {
+            e.Counter("controller25_op25_tsbks_total", "Trunking signalling blocks decoded, by NAC. Use rate() for the TSBK rate.",
+                d.stats.TSBKs[nac], d.label, metrics.Label{Name: "nac", Value: nac})
+        }
+    }
+}
@@ -0,0 +1,20 @@
+package metrics
+
+import (
+    "net/http"
+    "time"
+)
+
+// InstrumentHandler observes the latency of every request served by next,
+// labelled with the mux pattern that matched it so the label set stays bounded.
+func (h *HistogramVec) InstrumentHandler(mux *http.ServeMux, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        _, route := mux.Handler(r)
+        if route == "" {
+            route = "unmatched"
+        }
+        start := time.Now()
+        next.ServeHTTP(w, r)
+        h.Observe(route, time.Since(start).Seconds())
+    })
+}
@@ -0,0 +1,191 @@
+package metrics
+
+import (
+    "bufio"
+    "fmt"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+)
+
+// Collector writes the current value of one or more metric families. All
+// samples of a family must be written by the same collector, back to back.
+type Collector func(e *Encoder)
+
+// Registry serves the Prometheus text exposition format for its collectors.
+type Registry struct {
+    mu         sync.Mutex
+    collectors []Collector
+}
+
+func NewRegistry() *Registry {
+    return &Registry{}
+}
+
+func (r *Registry) Register(c Collector) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.collectors = append(r.collectors, c)
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+    bw := bufio.NewWriter(w)
+    e := &Encoder{w: bw, seen: make(map[string]bool)}
+    r.mu.Lock()
+    collectors := append([]Collector{}, r.collectors...)
+    r.mu.Unlock()
+    for _, c := range collectors {
+        c(e)
+    }
+    bw.Flush()
+}
+
+// Label is a metric label name and value.
+type Label struct {
+    Name  string
+    Value string
+}
+
+// Encoder writes samples in the Prometheus text format, emitting the HELP
+// and TYPE lines before the first sample of each family.
+type Encoder struct {
+    w    *bufio.Writer
+    seen map[string]bool
+}
+
+func (e *Encoder) Counter(name, help string, value float64, labels ...Label) {
+    e.header(name, help, "counter")
+    e.sample(name, value, labels)
+}
+
+func (e *Encoder) Gauge(name, help string, value float64, labels ...Label) {
+    e.header(name, help, "gauge")
+    e.sample(name, value, labels)
+}
+
+func (e *Encoder) header(name, help, typ string) {
+    if e.seen[name] {
+        return
+    }
+    e.seen[name] = true
+    fmt.Fprintf(e.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (e *Encoder) sample(name string, value float64, labels []Label) {
+    e.w.WriteString(name)
+    if len(labels) > 0 {
+        parts := make([]string, len(labels))
+        for i, l := range labels {
+            parts[i] = l.Name + `="` + escapeLabel(l.Value) + `"`
+        }
+        e.w.WriteString("{" + strings.Join(parts, ",") + "}")
+    }
+    e.w.WriteString(" " + formatValue(value) + "\n")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+    return labelEscaper.Replace(v)
+}
+
+func formatValue(v float64) string {
+    switch {
+    case math.IsInf(v, 1):
+        return "+Inf"
+    case math.IsInf(v, -1):
+        return "-Inf"
+    case math.IsNaN(v):
+        return "NaN"
+    }
+    return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a monotonically increasing count owned by the controller.
+type Counter struct {
+    v atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+    c.v.Add(1)
+}
+
+func (c *Counter) Value() uint64 {
+    return c.v.Load()
+}
+
+// HistogramVec is a histogram partitioned by the value of a single label.
+type HistogramVec struct {
+    name    string
+    help    string
+    label   string
+    buckets []float64
+    mu      sync.Mutex
+    series  map[string]*histogram
+}
+
+type histogram struct {
+    counts []uint64 // per bucket, not cumulative
+    count  uint64
+    sum    float64
+}
+
+// DefaultBuckets suit HTTP request latencies in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+    return &HistogramVec{
+        name:    name,
+        help:    help,
+        label:   label,
+        buckets: buckets,
+        series:  make(map[string]*histogram),
+    }
+}
+
+func (h *HistogramVec) Observe(labelValue string, v float64) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    s, ok := h.series[labelValue]
+    if !ok {
+        s = &histogram{counts: make([]uint64, len(h.buckets))}
+        h.series[labelValue] = s
+    }
+    for i, bound := range h.buckets {
+        if v <= bound {
+            s.counts[i]++
+            break
+        }
+    }
+    s.count++
+    s.sum += v
+}
+
+// Collect writes the histogram; use it as a Collector.
+func (h *HistogramVec) Collect(e *Encoder) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    e.header(h.name, h.help, "histogram")
+    values := make([]string, 0, len(h.series))
+    for v := range h.series {
+        values = append(values, v)
+    }
+    sort.Strings(values)
+    for _, v := range values {
+        s := h.series[v]
+        label := Label{h.label, v}
+        var cumulative uint64
+        for i, bound := range h.buckets {
+            cumulative += s.counts[i]
+            e.sample(h.name+"_bucket", float64(cumulative), []Label{label, {"le", formatValue(bound)}})
+        }
+        e.sample(h.name+"_bucket", float64(s.count), []Label{label, {"le", "+Inf"}})
+        e.sample(h.name+"_sum", s.sum, []Label{label})
+        e.sample(h.name+"_count", float64(s.count), []Label{label})
+    }
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+
+    "controller25/config"
+    "controller25/multirx"
+    "controller25/receiver"
+)
+
+func registerMultiRxHandlers() {
+    // Reads (GET) or creates and replaces (PUT) the receiver's multi_rx.py
+    // configuration. Audio destinations and the terminal are replaced when
+    // OP25 starts, so they don't need to match the receiver's ports.
+    handleReceiver("/api/multirx", "/api/receivers/{id}/multirx", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        file := rx.Profile.MultiRxFile
+        switch r.Method {
+        case http.MethodGet:
+            mrx, err := multirx.Read(file)
+            if err != nil {
+                status := http.StatusInternalServerError
+                if multirx.IsNotExist(err) {
+                    status = http.StatusNotFound
+                }
+                w.WriteHeader(status)
+                _ = json.NewEncoder(w).Encode(MultiRxResponse{File: file, Error: err.Error()})
+                return
+            }
+            _ = json.NewEncoder(w).Encode(MultiRxResponse{File: file, Config: mrx, Errors: errorStrings(mrx.Validate())})
+        case http.MethodPut:
+            mrx, ok := decodeMultiRx(w, r)
+            if !ok {
+                return
+            }
+            if errs := mrx.Validate(); len(errs) > 0 {
+                w.WriteHeader(http.StatusUnprocessableEntity)
+                _ = json.NewEncoder(w).Encode(MultiRxResponse{File: file, Errors: errorStrings(errs)})
+                return
+            }
+            if err := multirx.Write(file, mrx); err != nil {
+                w.WriteHeader(http.StatusInternalServerError)
+                _ = json.NewEncoder(w).Encode(MultiRxResponse{File: file, Error: err.Error()})
+                return
+            }
+            _ = json.NewEncoder(w).Encode(MultiRxResponse{
+                File:        file,
+                Config:      mrx,
+                Saved:       true,
+                Op25Restart: rx.Profile.Mode == config.ModeMultiRx && rx.Running(),
+            })
+        default:
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    // Checks a configuration without saving it
+    handleReceiver("/api/multirx/validate", "/api/receivers/{id}/multirx/validate", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        mrx, ok := decodeMultiRx(w, r)
+        if !ok {
+            return
+        }
+        errs := errorStrings(mrx.Validate())
+        _ = json.NewEncoder(w).Encode(MultiRxValidateResponse{Valid: len(errs) == 0, Errors: errs})
+    })
+}
+
+// decodeMultiRx reads a configuration from the request body, refusing
+// unknown keys so typos don't silently drop settings. It answers the
+// request itself when that fails.
+func decodeMultiRx(w http.ResponseWriter, r *http.Request) (*multirx.Config, bool) {
+    var mrx multirx.Config
+    dec := json.NewDecoder(r.Body)
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&mrx); err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        _ = json.NewEncoder(w).Encode(MultiRxValidateResponse{Errors: []string{"Invalid request body: " + err.Error()}})
+        return nil, false
+    }
+    return &mrx, true
+}
+
+func errorStrings(errs []error) []string {
+    var out []string
+    for _, err := range errs {
+        out = append(out, err.Error())
+    }
+    return out
+}
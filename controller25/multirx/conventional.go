@@ -0,0 +1,100 @@
+package multirx
+
+import (
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+
+    "controller25/config"
+    "controller25/sdr"
+)
+
+// Every channel is decoded at once, so they all have to fit in the part of
+// the SDR's bandwidth outside the filter roll-off.
+const usableBWPct = 0.85
+
+// Half of a 12.5 kHz channel
+const halfChannel = 6250.0
+
+// DeviceFromFlags builds the SDR from the rx.py flags a profile uses to
+// pick it: --args, -N/--gains, -S/--sample-rate and -q/--freq-corr.
+func DeviceFromFlags(flags []string) Device {
+    d := Device{Name: "sdr0", Args: sdr.ArgsFromFlags(flags), Rate: 1000000, UsableBWPct: usableBWPct}
+    if d.Args == "" {
+        d.Args = "rtl"
+    }
+    for i := 0; i+1 < len(flags); i++ {
+        value := strings.Trim(flags[i+1], `'"`)
+        switch flags[i] {
+        case "-N", "--gains":
+            d.Gains = value
+        case "-S", "--sample-rate":
+            if rate, err := strconv.ParseFloat(value, 64); err == nil {
+                d.Rate = int(rate)
+            }
+        case "-q", "--freq-corr":
+            d.PPM, _ = strconv.ParseFloat(value, 64)
+        }
+    }
+    return d
+}
+
+// Conventional generates the multi_rx.py configuration for a channel list:
+// one fixed decoder per channel on dev, tuned to the middle of the list.
+// Channel i sends audio to audioAddr's port plus i (see
+// config.ChannelAudioAddrs), where the receiver scans them; terminalType
+// ("http:host:port") is optional.
+func Conventional(channels []config.Channel, dev Device, audioAddr, terminalType string) (*Config, error) {
+    if len(channels) == 0 {
+        return nil, fmt.Errorf("no channels configured")
+    }
+    low, high := math.Inf(1), math.Inf(-1)
+    for _, ch := range channels {
+        low = math.Min(low, ch.Frequency*1e6)
+        high = math.Max(high, ch.Frequency*1e6)
+    }
+    if span, usable := high-low+2*halfChannel, float64(dev.Rate)*dev.UsableBWPct; span > usable {
+        return nil, fmt.Errorf("channels span %.4f MHz, more than the %.4f MHz the SDR covers at %d S/s", span/1e6, usable/1e6, dev.Rate)
+    }
+    dev.Frequency = math.Round((low + high) / 2)
+    dev.Tunable = false
+
+    destinations, err := udpDestinations(audioAddr, len(channels))
+    if err != nil {
+        return nil, err
+    }
+
+    cfg := &Config{Devices: []Device{dev}}
+    for i, ch := range channels {
+        c := Channel{
+            Name:         ch.Label,
+            Device:       dev.Name,
+            Frequency:    math.Round(ch.Frequency * 1e6),
+            DemodType:    "fsk4",
+            FilterType:   "rc",
+            ExcessBW:     0.2,
+            IFRate:       24000,
+            SymbolRate:   4800,
+            EnableAnalog: "off",
+            Destination:  destinations[i],
+        }
+        if c.Name == "" {
+            c.Name = strconv.FormatFloat(ch.Frequency, 'f', -1, 64)
+        }
+        switch ch.Modulation {
+        case config.ModulationCQPSK:
+            c.DemodType = "cqpsk"
+        case config.ModulationFM:
+            c.EnableAnalog = "on"
+        }
+        if ch.NAC != "" {
+            c.NAC, _ = config.ParseNAC(ch.NAC)
+        }
+        cfg.Channels = append(cfg.Channels, c)
+    }
+    if terminalType != "" {
+        cfg.Terminal = &Terminal{Module: "terminal.py", TerminalType: terminalType}
+    }
+    return cfg, nil
+}
@@ -0,0 +1,238 @@
+package multirx
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "os"
+    "slices"
+
+    "controller25/config"
+)
+
+// Config is a multi_rx.py configuration file: the SDRs to open, the
+// channels decoded from them, trunking for channels that follow a control
+// channel, and optional audio and terminal modules.
+type Config struct {
+    Devices  []Device  `json:"devices"`
+    Channels []Channel `json:"channels"`
+    Trunking *Trunking `json:"trunking,omitempty"`
+    Audio    *Audio    `json:"audio,omitempty"`
+    Terminal *Terminal `json:"terminal,omitempty"`
+    // Passed through as is, e.g. icecast streaming settings
+    Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+// Device is an SDR. A device that isn't tunable stays on Frequency and
+// every channel using it has to fall within its usable bandwidth.
+type Device struct {
+    Name        string  `json:"name"`
+    Args        string  `json:"args"`
+    Gains       string  `json:"gains,omitempty"`
+    GainMode    bool    `json:"gain_mode"`
+    Frequency   float64 `json:"frequency"` // Hz
+    Offset      float64 `json:"offset"`
+    PPM         float64 `json:"ppm"`
+    Rate        int     `json:"rate"`
+    UsableBWPct float64 `json:"usable_bw_pct"`
+    Tunable     bool    `json:"tunable"`
+}
+
+// Channel is one decoder. A channel with a TrunkingSysname follows that
+// trunked system; otherwise it stays on Frequency. Destination is where its
+// audio goes, e.g. "udp://127.0.0.1:23456". Decode selects another decoder
+// than P25, e.g. "dmr_bs:slot=1".
+type Channel struct {
+    Name              string  `json:"name"`
+    Device            string  `json:"device"`
+    TrunkingSysname   string  `json:"trunking_sysname,omitempty"`
+    MetaStreamName    string  `json:"meta_stream_name,omitempty"`
+    Frequency         float64 `json:"frequency,omitempty"` // Hz
+    DemodType         string  `json:"demod_type"`
+    CQPSKTracking     *bool   `json:"cqpsk_tracking,omitempty"`
+    TrackingThreshold float64 `json:"tracking_threshold,omitempty"`
+    TrackingFeedback  float64 `json:"tracking_feedback,omitempty"`
+    FilterType        string  `json:"filter_type"`
+    ExcessBW          float64 `json:"excess_bw"`
+    IFRate            int     `json:"if_rate"`
+    SymbolRate        int     `json:"symbol_rate"`
+    NAC               int     `json:"nac,omitempty"`
+    EnableAnalog      string  `json:"enable_analog,omitempty"`
+    Decode            string  `json:"decode,omitempty"`
+    Destination       string  `json:"destination"`
+    Whitelist         string  `json:"whitelist,omitempty"`
+    Blacklist         string  `json:"blacklist,omitempty"`
+    Plot              string  `json:"plot"`
+}
+
+// Trunking is the trunking module and the systems channels can follow.
+type Trunking struct {
+    Module string       `json:"module"` // e.g. "tk_p25.py"
+    Chans  []TrunkedSys `json:"chans"`
+}
+
+// TrunkedSys is a trunked system: trunk.tsv's columns in multi_rx.py's
+// spelling.
+type TrunkedSys struct {
+    NAC                string `json:"nac"`
+    Sysname            string `json:"sysname"`
+    ControlChannelList string `json:"control_channel_list"` // MHz, comma separated
+    Whitelist          string `json:"whitelist,omitempty"`
+    Blacklist          string `json:"blacklist,omitempty"`
+    TGIDTagsFile       string `json:"tgid_tags_file,omitempty"`
+    TDMACC             bool   `json:"tdma_cc,omitempty"`
+    CryptBehavior      int    `json:"crypt_behavior,omitempty"`
+}
+
+// Audio plays channel audio locally. The controller streams audio itself,
+// so this is rarely needed.
+type Audio struct {
+    Module    string          `json:"module"` // e.g. "sockaudio.py"
+    Instances []AudioInstance `json:"instances"`
+}
+
+type AudioInstance struct {
+    InstanceName   string  `json:"instance_name"`
+    DeviceName     string  `json:"device_name"`
+    UDPPort        int     `json:"udp_port"`
+    AudioGain      float64 `json:"audio_gain"`
+    NumberChannels int     `json:"number_channels"`
+}
+
+// Terminal is multi_rx.py's terminal, e.g. TerminalType "http:127.0.0.1:8080".
+type Terminal struct {
+    Module             string  `json:"module"`
+    TerminalType       string  `json:"terminal_type"`
+    TerminalTimeout    float64 `json:"terminal_timeout,omitempty"`
+    CursesPlotInterval float64 `json:"curses_plot_interval,omitempty"`
+    HTTPPlotInterval   float64 `json:"http_plot_interval,omitempty"`
+    HTTPPlotDirectory  string  `json:"http_plot_directory,omitempty"`
+    TuningStepLarge    int     `json:"tuning_step_large,omitempty"`
+    TuningStepSmall    int     `json:"tuning_step_small,omitempty"`
+}
+
+// Redirect returns a copy of c with each channel's audio sent to its own
+// port from audioAddr on, where the controller listens (see
+// config.ChannelAudioAddrs), and, if terminalType is set, the HTTP terminal
+// moved there.
+func (c *Config) Redirect(audioAddr, terminalType string) (*Config, error) {
+    destinations, err := udpDestinations(audioAddr, len(c.Channels))
+    if err != nil {
+        return nil, err
+    }
+    out := *c
+    out.Channels = append([]Channel{}, c.Channels...)
+    for i := range out.Channels {
+        out.Channels[i].Destination = destinations[i]
+    }
+    if terminalType != "" {
+        terminal := Terminal{Module: "terminal.py"}
+        if c.Terminal != nil {
+            terminal = *c.Terminal
+        }
+        terminal.TerminalType = terminalType
+        out.Terminal = &terminal
+    }
+    return &out, nil
+}
+
+// Files returns the tags, whitelist and blacklist files the configuration
+// names, as written.
+func (c *Config) Files() []string {
+    var files []string
+    for _, ch := range c.Channels {
+        files = append(files, ch.Whitelist, ch.Blacklist)
+    }
+    if c.Trunking != nil {
+        for _, sys := range c.Trunking.Chans {
+            files = append(files, sys.TGIDTagsFile, sys.Whitelist, sys.Blacklist)
+        }
+    }
+    return slices.DeleteFunc(files, func(name string) bool { return name == "" })
+}
+
+// ResolveFiles returns a copy with the tags, whitelist and blacklist files
+// passed through resolve, since multi_rx.py would resolve relative ones
+// against its working directory.
+func (c *Config) ResolveFiles(resolve func(string) string) *Config {
+    out := *c
+    out.Channels = append([]Channel{}, c.Channels...)
+    for i := range out.Channels {
+        out.Channels[i].Whitelist = resolve(out.Channels[i].Whitelist)
+        out.Channels[i].Blacklist = resolve(out.Channels[i].Blacklist)
+    }
+    if c.Trunking != nil {
+        trunking := *c.Trunking
+        trunking.Chans = append([]TrunkedSys{}, c.Trunking.Chans...)
+        for i := range trunking.Chans {
+            sys := &trunking.Chans[i]
+            sys.TGIDTagsFile = resolve(sys.TGIDTagsFile)
+            sys.Whitelist = resolve(sys.Whitelist)
+            sys.Blacklist = resolve(sys.Blacklist)
+        }
+        out.Trunking = &trunking
+    }
+    return &out
+}
+
+// udpDestination turns a listen address into a channel destination.
+func udpDestination(addr string) (string, error) {
+    host, port, err := net.SplitHostPort(addr)
+    if err != nil {
+        return "", fmt.Errorf("audio address: %v", err)
+    }
+    if host == "" || host == "0.0.0.0" {
+        host = "127.0.0.1"
+    }
+    return "udp://" + net.JoinHostPort(host, port), nil
+}
+
+// udpDestinations returns the destinations of n channels whose audio the
+// receiver listening on addr streams, one port each.
+func udpDestinations(addr string, n int) ([]string, error) {
+    addrs, err := config.ChannelAudioAddrs(addr, n)
+    if err != nil {
+        return nil, fmt.Errorf("audio address: %v", err)
+    }
+    destinations := make([]string, n)
+    for i, addr := range addrs {
+        if destinations[i], err = udpDestination(addr); err != nil {
+            return nil, err
+        }
+    }
+    return destinations, nil
+}
+
+// Read reads a configuration file. A missing file is reported as
+// os.ErrNotExist.
+func Read(filename string) (*Config, error) {
+    data, err := os.ReadFile(filename)
+    if err != nil {
+        return nil, err
+    }
+    var cfg Config
+    if err := json.Unmarshal(data, &cfg); err != nil {
+        return nil, fmt.Errorf("%s: %v", filename, err)
+    }
+    return &cfg, nil
+}
+
+// Write writes cfg as JSON to filename, through a temporary file so
+// multi_rx.py never reads a half-written one.
+func Write(filename string, cfg *Config) error {
+    data, err := json.MarshalIndent(cfg, "", "    ")
+    if err != nil {
+        return err
+    }
+    tmp := filename + ".tmp"
+    if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, filename)
+}
+
+// IsNotExist reports whether err is Read's error for a missing file.
+func IsNotExist(err error) bool {
+    return errors.Is(err, os.ErrNotExist)
+}
@@ -0,0 +1,136 @@
+package multirx
+
+import (
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+)
+
+// Validate returns every problem multi_rx.py would trip over, or nil.
+func (c *Config) Validate() []error {
+    var errs []error
+    errorf := func(format string, args ...any) {
+        errs = append(errs, fmt.Errorf(format, args...))
+    }
+
+    devices := make(map[string]Device)
+    if len(c.Devices) == 0 {
+        errorf("devices: at least one device is required")
+    }
+    for i, d := range c.Devices {
+        at := fmt.Sprintf("devices[%d]", i)
+        switch {
+        case d.Name == "":
+            errorf("%s.name: required", at)
+        case devices[d.Name].Name != "":
+            errorf("%s.name: %q is used twice", at, d.Name)
+        }
+        devices[d.Name] = d
+        if d.Args == "" {
+            errorf("%s.args: required", at)
+        }
+        if d.Rate <= 0 {
+            errorf("%s.rate: must be positive", at)
+        }
+        if d.UsableBWPct <= 0 || d.UsableBWPct > 1 {
+            errorf("%s.usable_bw_pct: must be in (0, 1]", at)
+        }
+        if !d.Tunable && d.Frequency <= 0 {
+            errorf("%s.frequency: required unless the device is tunable", at)
+        }
+    }
+
+    systems := make(map[string]bool)
+    if c.Trunking != nil {
+        if c.Trunking.Module == "" {
+            errorf("trunking.module: required")
+        }
+        for i, sys := range c.Trunking.Chans {
+            at := fmt.Sprintf("trunking.chans[%d]", i)
+            switch {
+            case sys.Sysname == "":
+                errorf("%s.sysname: required", at)
+            case systems[sys.Sysname]:
+                errorf("%s.sysname: %q is used twice", at, sys.Sysname)
+            }
+            systems[sys.Sysname] = true
+            if sys.ControlChannelList == "" {
+                errorf("%s.control_channel_list: required", at)
+            }
+            for _, f := range strings.Split(sys.ControlChannelList, ",") {
+                if _, err := strconv.ParseFloat(strings.TrimSpace(f), 64); err != nil && sys.ControlChannelList != "" {
+                    errorf("%s.control_channel_list: %q is not a frequency in MHz", at, f)
+                }
+            }
+            if sys.NAC != "" {
+                if _, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(sys.NAC), "0x"), 16, 12); err != nil {
+                    errorf("%s.nac: %q is not a 12-bit hex value", at, sys.NAC)
+                }
+            }
+        }
+    }
+
+    if len(c.Channels) == 0 {
+        errorf("channels: at least one channel is required")
+    }
+    names := make(map[string]bool)
+    for i, ch := range c.Channels {
+        at := fmt.Sprintf("channels[%d]", i)
+        if names[ch.Name] && ch.Name != "" {
+            errorf("%s.name: %q is used twice", at, ch.Name)
+        }
+        names[ch.Name] = true
+        dev, ok := devices[ch.Device]
+        if !ok {
+            errorf("%s.device: no device named %q", at, ch.Device)
+        }
+        if ch.DemodType != "fsk4" && ch.DemodType != "cqpsk" {
+            errorf("%s.demod_type: %q (use \"fsk4\" or \"cqpsk\")", at, ch.DemodType)
+        }
+        if ch.IFRate <= 0 {
+            errorf("%s.if_rate: must be positive", at)
+        }
+        if ch.SymbolRate <= 0 {
+            errorf("%s.symbol_rate: must be positive", at)
+        }
+        if ch.ExcessBW < 0 || ch.ExcessBW > 1 {
+            errorf("%s.excess_bw: must be in [0, 1]", at)
+        }
+        switch ch.EnableAnalog {
+        case "", "on", "off", "auto":
+        default:
+            errorf("%s.enable_analog: %q (use \"on\", \"off\" or \"auto\")", at, ch.EnableAnalog)
+        }
+        if ch.TrunkingSysname != "" {
+            if !systems[ch.TrunkingSysname] {
+                errorf("%s.trunking_sysname: no trunking system named %q", at, ch.TrunkingSysname)
+            }
+            continue
+        }
+        if ch.Frequency <= 0 {
+            errorf("%s.frequency: required for channels that aren't trunked", at)
+            continue
+        }
+        if ok && !dev.Tunable && dev.Rate > 0 {
+            half := float64(dev.Rate) * dev.UsableBWPct / 2
+            if math.Abs(ch.Frequency-(dev.Frequency+dev.Offset)) > half {
+                errorf("%s.frequency: %.0f Hz is outside %s's %.0f-%.0f Hz", at, ch.Frequency, dev.Name,
+                    dev.Frequency+dev.Offset-half, dev.Frequency+dev.Offset+half)
+            }
+        }
+    }
+
+    if c.Audio != nil {
+        for i, inst := range c.Audio.Instances {
+            if inst.UDPPort <= 0 || inst.UDPPort > 65535 {
+                errorf("audio.instances[%d].udp_port: %d is not a port", i, inst.UDPPort)
+            }
+        }
+    }
+    if c.Terminal != nil && c.Terminal.TerminalType != "" && c.Terminal.TerminalType != "curses" &&
+        !strings.HasPrefix(c.Terminal.TerminalType, "http:") {
+        errorf("terminal.terminal_type: %q (use \"curses\" or \"http:host:port\")", c.Terminal.TerminalType)
+    }
+    return errs
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+
+    "controller25/config"
+    "controller25/mdns"
+    "controller25/radioreference"
+    "controller25/receiver"
+)
+
+func registerRadioReferenceHandlers(mdnsService *mdns.Service) {
+    // Imports a RadioReference system into the receiver's trunk file and a
+    // tags file next to it. The credentials are only passed through.
+    handleReceiver("/api/radioreference/import", "/api/receivers/{id}/radioreference/import", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        var req RRImportRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            w.WriteHeader(http.StatusBadRequest)
+            _ = json.NewEncoder(w).Encode(RRImportResponse{Error: "Invalid request body"})
+            return
+        }
+        if req.Username == "" || req.Password == "" || req.SystemID <= 0 {
+            w.WriteHeader(http.StatusBadRequest)
+            _ = json.NewEncoder(w).Encode(RRImportResponse{Error: "username, password and system_id are required"})
+            return
+        }
+
+        client := radioreference.NewClient(currentConfig.Load().RR)
+        creds := radioreference.Credentials{Username: req.Username, Password: req.Password}
+        sys, err := client.TrsDetails(r.Context(), creds, req.SystemID)
+        if err != nil {
+            w.WriteHeader(http.StatusBadGateway)
+            _ = json.NewEncoder(w).Encode(RRImportResponse{Error: err.Error()})
+            return
+        }
+        sites, err := client.TrsSites(r.Context(), creds, req.SystemID)
+        if err != nil {
+            w.WriteHeader(http.StatusBadGateway)
+            _ = json.NewEncoder(w).Encode(RRImportResponse{Error: err.Error()})
+            return
+        }
+        site, err := pickSite(sites, req.SiteID)
+        if err != nil {
+            w.WriteHeader(http.StatusBadRequest)
+            _ = json.NewEncoder(w).Encode(RRImportResponse{Sites: sites, Error: err.Error()})
+            return
+        }
+        if site.ControlChannels() == "" {
+            w.WriteHeader(http.StatusBadRequest)
+            _ = json.NewEncoder(w).Encode(RRImportResponse{Error: fmt.Sprintf("site %d lists no control channels", site.ID)})
+            return
+        }
+        talkgroups, err := client.TrsTalkgroups(r.Context(), creds, req.SystemID)
+        if err != nil {
+            w.WriteHeader(http.StatusBadGateway)
+            _ = json.NewEncoder(w).Encode(RRImportResponse{Error: err.Error()})
+            return
+        }
+
+        tagsFile := fmt.Sprintf("rr-%d-tags.tsv", req.SystemID)
+        tags := radioreference.Tags(talkgroups, req.IncludeEncrypted)
+        // trunk.tsv names it relative to the data dir
+        if err := config.WriteTags(currentConfig.Load().DataPath(tagsFile), tags); err != nil {
+            _ = json.NewEncoder(w).Encode(RRImportResponse{Error: err.Error()})
+            return
+        }
+        trunk := radioreference.TrunkSystem(sys, site, tagsFile)
+        trunk.Modulation = req.Modulation
+        if err := config.WriteTrunkSystem(rx.Profile.TrunkFile, &trunk); err != nil {
+            _ = json.NewEncoder(w).Encode(RRImportResponse{Error: err.Error()})
+            return
+        }
+        if rx.ID == config.DefaultReceiver {
+            mdnsService.Set(map[string]string{"sys": trunk.SysName})
+        }
+        log.Printf("Imported RadioReference system %d site %d (%s) into %s for receiver %s: %d talkgroups",
+            req.SystemID, site.ID, trunk.SysName, rx.Profile.TrunkFile, rx.ID, len(tags))
+
+        _ = json.NewEncoder(w).Encode(RRImportResponse{
+            Success:        true,
+            SysName:        trunk.SysName,
+            ControlChannel: trunk.ControlChannel,
+            NAC:            trunk.NAC,
+            TagsFile:       tagsFile,
+            Talkgroups:     len(tags),
+        })
+    })
+}
+
+// pickSite returns the site with the given id. Without one, a system with a
+// single site needs no choice.
+func pickSite(sites []radioreference.Site, id int) (radioreference.Site, error) {
+    if id == 0 {
+        if len(sites) == 1 {
+            return sites[0], nil
+        }
+        return radioreference.Site{}, fmt.Errorf("system has %d sites, site_id is required", len(sites))
+    }
+    for _, site := range sites {
+        if site.ID == id {
+            return site, nil
+        }
+    }
+    return radioreference.Site{}, fmt.Errorf("site %d not found", id)
+}
@@ -0,0 +1,228 @@
+package radioreference
+
+import (
+    "bytes"
+    "context"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "controller25/config"
+)
+
+const namespace = "http://api.radioreference.com/soap2/"
+
+// Credentials are a RadioReference premium account. They are only used for
+// the calls they are passed to and never stored.
+type Credentials struct {
+    Username string
+    Password string
+}
+
+// Client calls the RadioReference SOAP web service.
+type Client struct {
+    cfg  config.RRConfig
+    http *http.Client
+}
+
+func NewClient(cfg config.RRConfig) *Client {
+    return &Client{cfg: cfg, http: &http.Client{Timeout: cfg.Timeout}}
+}
+
+type param struct {
+    name  string
+    value string
+}
+
+// call posts an rpc-style request and returns the element holding the
+// result, or the SOAP fault as an error.
+func (c *Client) call(ctx context.Context, creds Credentials, method string, params ...param) (*node, error) {
+    var body bytes.Buffer
+    body.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+    body.WriteString(`<soap:Envelope xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>`)
+    fmt.Fprintf(&body, `<%s xmlns="%s">`, method, namespace)
+    for _, p := range params {
+        writeElement(&body, p.name, p.value)
+    }
+    body.WriteString("<authInfo>")
+    writeElement(&body, "appKey", c.cfg.AppKey)
+    writeElement(&body, "username", creds.Username)
+    writeElement(&body, "password", creds.Password)
+    writeElement(&body, "version", "latest")
+    writeElement(&body, "style", "rpc")
+    body.WriteString("</authInfo>")
+    fmt.Fprintf(&body, "</%s></soap:Body></soap:Envelope>", method)
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.Endpoint, &body)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+    req.Header.Set("SOAPAction", namespace+method)
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", method, err)
+    }
+    defer resp.Body.Close()
+    data, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", method, err)
+    }
+
+    var envelope node
+    if err := xml.Unmarshal(data, &envelope); err != nil {
+        return nil, fmt.Errorf("%s: HTTP %d, invalid response: %v", method, resp.StatusCode, err)
+    }
+    // Faults come with HTTP 500, e.g. for a bad login or an unknown system
+    if fault := envelope.find("Fault"); fault != nil {
+        return nil, fmt.Errorf("%s: %s", method, fault.text("faultstring"))
+    }
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("%s: HTTP %d", method, resp.StatusCode)
+    }
+    if result := envelope.find("return"); result != nil {
+        return result, nil
+    }
+    if result := envelope.find(method + "Result"); result != nil {
+        return result, nil
+    }
+    return nil, fmt.Errorf("%s: response has no result", method)
+}
+
+func writeElement(w *bytes.Buffer, name, value string) {
+    fmt.Fprintf(w, "<%s>", name)
+    xml.EscapeText(w, []byte(value))
+    fmt.Fprintf(w, "</%s>", name)
+}
+
+// System is a trunked radio system (getTrsDetails).
+type System struct {
+    ID     int    `json:"id"`
+    Name   string `json:"name"`
+    Type   string `json:"type"`
+    Flavor string `json:"flavor,omitempty"`
+    Voice  string `json:"voice,omitempty"`
+}
+
+// Site is one site of a trunked system (getTrsSites).
+type Site struct {
+    ID          int        `json:"id"`
+    Number      string     `json:"number"`
+    Description string     `json:"description"`
+    RFSS        string     `json:"rfss,omitempty"`
+    NAC         string     `json:"nac,omitempty"`
+    Freqs       []SiteFreq `json:"freqs"`
+}
+
+// SiteFreq is a site frequency in MHz. Use is "d" for the primary control
+// channel, "a" for alternates and empty for voice channels.
+type SiteFreq struct {
+    LCN  string `json:"lcn,omitempty"`
+    Freq string `json:"freq"`
+    Use  string `json:"use,omitempty"`
+}
+
+// Talkgroup is a talkgroup of a trunked system (getTrsTalkgroups).
+type Talkgroup struct {
+    Dec         int    `json:"dec"`
+    Alpha       string `json:"alpha"`
+    Description string `json:"description"`
+    Mode        string `json:"mode"`
+    Encrypted   bool   `json:"encrypted"`
+}
+
+func (c *Client) TrsDetails(ctx context.Context, creds Credentials, sid int) (System, error) {
+    result, err := c.call(ctx, creds, "getTrsDetails", param{"sid", strconv.Itoa(sid)})
+    if err != nil {
+        return System{}, err
+    }
+    return System{
+        ID:     sid,
+        Name:   result.text("sName"),
+        Type:   result.text("sType"),
+        Flavor: result.text("sFlavor"),
+        Voice:  result.text("sVoice"),
+    }, nil
+}
+
+func (c *Client) TrsSites(ctx context.Context, creds Credentials, sid int) ([]Site, error) {
+    result, err := c.call(ctx, creds, "getTrsSites", param{"sid", strconv.Itoa(sid)})
+    if err != nil {
+        return nil, err
+    }
+    sites := []Site{}
+    for _, item := range result.Children {
+        site := Site{
+            Number:      item.text("siteNumber"),
+            Description: item.text("siteDescr"),
+            RFSS:        item.text("rfss"),
+            NAC:         item.text("nac"),
+        }
+        site.ID, _ = strconv.Atoi(item.text("siteId"))
+        if freqs := item.find("siteFreqs"); freqs != nil {
+            for _, f := range freqs.Children {
+                site.Freqs = append(site.Freqs, SiteFreq{LCN: f.text("lcn"), Freq: f.text("freq"), Use: f.text("use")})
+            }
+        }
+        sites = append(sites, site)
+    }
+    return sites, nil
+}
+
+// TrsTalkgroups returns every talkgroup of the system, unfiltered.
+func (c *Client) TrsTalkgroups(ctx context.Context, creds Credentials, sid int) ([]Talkgroup, error) {
+    result, err := c.call(ctx, creds, "getTrsTalkgroups",
+        param{"sid", strconv.Itoa(sid)}, param{"tgCid", "0"}, param{"tgTag", "0"}, param{"tgDec", "0"})
+    if err != nil {
+        return nil, err
+    }
+    talkgroups := []Talkgroup{}
+    for _, item := range result.Children {
+        tg := Talkgroup{
+            Alpha:       item.text("tgAlpha"),
+            Description: item.text("tgDescr"),
+            Mode:        item.text("tgMode"),
+        }
+        tg.Dec, _ = strconv.Atoi(item.text("tgDec"))
+        if enc := item.text("tgEnc"); enc != "" && enc != "0" {
+            tg.Encrypted = true
+        }
+        talkgroups = append(talkgroups, tg)
+    }
+    return talkgroups, nil
+}
+
+// node is any XML element. The API answers in SOAP-encoded rpc style, with
+// arrays as <item> lists, and the parsing only relies on element names.
+type node struct {
+    XMLName  xml.Name
+    Content  string `xml:",chardata"`
+    Children []node `xml:",any"`
+}
+
+// find returns the first descendant named local, depth first.
+func (n *node) find(local string) *node {
+    for i := range n.Children {
+        child := &n.Children[i]
+        if child.XMLName.Local == local {
+            return child
+        }
+        if found := child.find(local); found != nil {
+            return found
+        }
+    }
+    return nil
+}
+
+// text returns the trimmed content of the direct child named local.
+func (n *node) text(local string) string {
+    for _, child := range n.Children {
+        if child.XMLName.Local == local {
+            return strings.TrimSpace(child.Content)
+        }
+    }
+    return ""
+}
@@ -0,0 +1,60 @@
+package radioreference
+
+import (
+    "sort"
+    "strings"
+
+    "controller25/config"
+)
+
+// ControlChannels returns the site's control channels, primary first, as
+// the comma separated MHz list trunk.tsv takes.
+func (s Site) ControlChannels() string {
+    var control []SiteFreq
+    for _, f := range s.Freqs {
+        if f.Use != "" {
+            control = append(control, f)
+        }
+    }
+    sort.SliceStable(control, func(i, j int) bool {
+        return control[i].Use == "d" && control[j].Use != "d"
+    })
+    freqs := make([]string, len(control))
+    for i, f := range control {
+        freqs[i] = f.Freq
+    }
+    return strings.Join(freqs, ",")
+}
+
+// TrunkSystem builds the trunk.tsv row for one site of sys. The NAC is the
+// site's if RadioReference lists one, otherwise 0 lets rx.py detect it.
+func TrunkSystem(sys System, site Site, tagsFile string) config.TrunkSystem {
+    nac := "0"
+    if site.NAC != "" {
+        nac = "0x" + strings.TrimPrefix(strings.ToLower(site.NAC), "0x")
+    }
+    return config.TrunkSystem{
+        SysName:        sys.Name,
+        ControlChannel: site.ControlChannels(),
+        NAC:            nac,
+        TagsFile:       tagsFile,
+    }
+}
+
+// Tags converts talkgroups to tags file lines, leaving out encrypted ones
+// unless includeEncrypted is set. The alpha tag is what rx.py shows, so
+// the description is only used when there is none.
+func Tags(talkgroups []Talkgroup, includeEncrypted bool) []config.Talkgroup {
+    tags := []config.Talkgroup{}
+    for _, tg := range talkgroups {
+        if tg.Encrypted && !includeEncrypted || tg.Dec <= 0 {
+            continue
+        }
+        tag := tg.Alpha
+        if tag == "" {
+            tag = tg.Description
+        }
+        tags = append(tags, config.Talkgroup{TGID: tg.Dec, Tag: tag})
+    }
+    return tags
+}
@@ -0,0 +1,89 @@
+package receiver
+
+import (
+    "net"
+    "strings"
+
+    "controller25/config"
+)
+
+// Long spellings of the rx.py options a profile may override.
+var longFlags = map[string]string{
+    "-w": "--wireshark",
+    "-W": "--wireshark-host",
+    "-u": "--wireshark-port",
+    "-l": "--terminal-type",
+    "-T": "--trunk-conf-file",
+}
+
+// Flags returns the flags to start rx.py with: the request's, or the
+// profile's when the request has none, with the audio destination, terminal
+// address and trunk file forced to the profile's.
+func Flags(profile config.ReceiverConfig, flags []string) []string {
+    if len(flags) == 0 {
+        flags = profile.Flags
+    }
+    flags = append([]string{}, flags...)
+    if profile.AudioUDPAddr != "" {
+        host, port, _ := net.SplitHostPort(profile.AudioUDPAddr)
+        if host == "" || host == "0.0.0.0" {
+            host = "127.0.0.1"
+        }
+        flags = setFlag(flags, "-W", host)
+        flags = setFlag(flags, "-u", port)
+        if !hasFlag(flags, "-w") {
+            flags = append(flags, "-w")
+        }
+    }
+    if profile.HTTPAddr != "" {
+        flags = setFlag(flags, "-l", "http:"+profile.HTTPAddr)
+    }
+    // Only trunked receivers have a trunk file
+    if profile.TrunkFile != "" && hasFlag(flags, "-T") {
+        flags = setFlag(flags, "-T", profile.TrunkFile)
+    }
+    return flags
+}
+
+func hasFlag(flags []string, short string) bool {
+    long := longFlags[short]
+    for _, flag := range flags {
+        if flag == short || flag == long || strings.HasPrefix(flag, long+"=") {
+            return true
+        }
+    }
+    return false
+}
+
+// setFlag removes every occurrence of an option that takes a value and
+// appends it with value.
+func setFlag(flags []string, short, value string) []string {
+    long := longFlags[short]
+    out := make([]string, 0, len(flags)+2)
+    for i := 0; i < len(flags); i++ {
+        switch {
+        case flags[i] == short || flags[i] == long:
+            i++ // skip the value too
+        case strings.HasPrefix(flags[i], long+"="):
+        default:
+            out = append(out, flags[i])
+        }
+    }
+    return append(out, short, value)
+}
+
+// flagValue returns the value of the last occurrence of an option that
+// takes one, without quotes, or "" if it isn't set.
+func flagValue(flags []string, short string) string {
+    long := longFlags[short]
+    var value string
+    for i, flag := range flags {
+        switch {
+        case (flag == short || flag == long) && i+1 < len(flags):
+            value = flags[i+1]
+        case strings.HasPrefix(flag, long+"="):
+            value = strings.TrimPrefix(flag, long+"=")
+        }
+    }
+    return strings.Trim(value, `'"`)
+}
@@ -0,0 +1,129 @@
+package receiver
+
+import (
+    "fmt"
+    "io"
+    "os/exec"
+
+    "controller25/audio"
+    "controller25/config"
+    "controller25/multirx"
+    "controller25/terminal"
+)
+
+// launch is a started OP25 app.
+type launch struct {
+    cmd            *exec.Cmd
+    stdout, stderr io.ReadCloser
+    audio          *audio.Broadcaster
+    flags          []string // reported by Status
+    terminalAddr   string   // HTTP terminal to dial, if any
+}
+
+// startApp binds the receiver's audio ports, one per channel, and starts
+// the OP25 app p with args. A port that can't be bound fails the start
+// before OP25 runs.
+func (r *Receiver) startApp(cfg *config.Config, p config.Process, args []string, channels int) (launch, error) {
+    audioCfg := cfg.Audio
+    audioCfg.UDPAddr = r.audioAddr(cfg)
+    sources, err := config.ChannelAudioAddrs(audioCfg.UDPAddr, channels)
+    if err != nil {
+        return launch{}, fmt.Errorf("audio: %v", err)
+    }
+    broadcaster := audio.NewBroadcaster(audioCfg, sources...)
+    if err := broadcaster.Start(); err != nil {
+        return launch{}, err
+    }
+    cmd, stdout, stderr, err := config.StartOp25App(p, args)
+    if err != nil {
+        broadcaster.Shutdown()
+        return launch{}, err
+    }
+    return launch{cmd: cmd, stdout: stdout, stderr: stderr, audio: broadcaster, flags: args}, nil
+}
+
+// startRx starts rx.py with flags (see Flags). The trunk file is passed as
+// a run file with the tags and list files it references made absolute, as
+// rx.py runs in its own working directory; Status still shows the flags.
+func (r *Receiver) startRx(cfg *config.Config, p config.Process, flags []string) (launch, error) {
+    args := flags
+    if trunkFile := flagValue(flags, "-T"); trunkFile != "" {
+        runFile := cfg.RunFile(r.Profile, ".tsv")
+        if err := config.WriteRunTrunkFile(cfg.DataPath(trunkFile), runFile, cfg.Server.DataDir); err != nil {
+            return launch{}, err
+        }
+        args = setFlag(flags, "-T", runFile)
+    }
+    l, err := r.startApp(cfg, p, args, 1)
+    l.flags, l.terminalAddr = flags, terminal.ParseAddr(flags)
+    return l, err
+}
+
+// startConventional generates multi_rx.py's configuration from the channel
+// list and starts it. flags are the profile's rx.py flags after Flags, which
+// still say which SDR to use, where audio goes and where the terminal
+// listens.
+func (r *Receiver) startConventional(cfg *config.Config, p config.Process, flags []string) (launch, error) {
+    channels, err := config.ReadChannels(r.Profile.ChannelsFile)
+    if err != nil {
+        return launch{}, err
+    }
+    mrx, err := multirx.Conventional(channels, multirx.DeviceFromFlags(flags), r.audioAddr(cfg), terminalType(flags))
+    if err != nil {
+        return launch{}, err
+    }
+    l, err := r.startMultiRxConfig(cfg, p, mrx)
+    l.flags, l.terminalAddr = flags, terminal.ParseAddr(flags)
+    return l, err
+}
+
+// startMultiRx starts multi_rx.py on the receiver's configuration, with
+// audio sent to the receiver's port and the terminal on its http_addr.
+func (r *Receiver) startMultiRx(cfg *config.Config, p config.Process) (launch, error) {
+    mrx, err := multirx.Read(r.Profile.MultiRxFile)
+    if multirx.IsNotExist(err) {
+        return launch{}, fmt.Errorf("no multi_rx.py configuration (%s)", r.Profile.MultiRxFile)
+    }
+    if err != nil {
+        return launch{}, err
+    }
+    if errs := mrx.Validate(); len(errs) > 0 {
+        return launch{}, fmt.Errorf("%s: %v", r.Profile.MultiRxFile, errs[0])
+    }
+    var terminalType string
+    if r.Profile.HTTPAddr != "" {
+        terminalType = "http:" + r.Profile.HTTPAddr
+    }
+    if mrx, err = mrx.Redirect(r.audioAddr(cfg), terminalType); err != nil {
+        return launch{}, err
+    }
+    l, err := r.startMultiRxConfig(cfg, p, mrx)
+    if mrx.Terminal != nil {
+        l.terminalAddr = terminal.ParseAddr([]string{"-l", mrx.Terminal.TerminalType})
+    }
+    return l, err
+}
+
+// startMultiRxConfig writes mrx to the run file, with the tags and list
+// files it names made absolute, and starts multi_rx.py on it.
+func (r *Receiver) startMultiRxConfig(cfg *config.Config, p config.Process, mrx *multirx.Config) (launch, error) {
+    file := cfg.RunFile(r.Profile, ".json")
+    // Tags and list files are relative to the data dir, not OP25's workdir
+    if err := multirx.Write(file, mrx.ResolveFiles(cfg.DataPath)); err != nil {
+        return launch{}, err
+    }
+    return r.startApp(cfg, p, []string{"-c", file}, len(mrx.Channels))
+}
+
+// audioAddr is where the receiver's audio broadcaster listens.
+func (r *Receiver) audioAddr(cfg *config.Config) string {
+    if r.Profile.AudioUDPAddr != "" {
+        return r.Profile.AudioUDPAddr
+    }
+    return cfg.Audio.UDPAddr
+}
+
+// terminalType returns the -l value, e.g. "http:127.0.0.1:8080".
+func terminalType(flags []string) string {
+    return flagValue(flags, "-l")
+}
@@ -0,0 +1,64 @@
+package receiver
+
+import (
+    "sync"
+
+    "controller25/config"
+)
+
+// Manager holds the receivers configured at startup, keyed by ID. The set
+// is fixed for the controller's lifetime.
+type Manager struct {
+    receivers map[string]*Receiver
+    ordered   []*Receiver
+}
+
+func NewManager(profiles []config.ReceiverConfig) *Manager {
+    m := &Manager{receivers: make(map[string]*Receiver)}
+    for _, profile := range profiles {
+        r := New(profile)
+        m.receivers[r.ID] = r
+        m.ordered = append(m.ordered, r)
+    }
+    return m
+}
+
+func (m *Manager) Get(id string) (*Receiver, bool) {
+    r, ok := m.receivers[id]
+    return r, ok
+}
+
+// Default returns the receiver the unscoped routes act on.
+func (m *Manager) Default() *Receiver {
+    return m.receivers[config.DefaultReceiver]
+}
+
+// All returns every receiver, the default first and the rest in config
+// order.
+func (m *Manager) All() []*Receiver {
+    return append([]*Receiver{}, m.ordered...)
+}
+
+// AnyRunning reports whether at least one receiver runs OP25.
+func (m *Manager) AnyRunning() bool {
+    for _, r := range m.ordered {
+        if r.Running() {
+            return true
+        }
+    }
+    return false
+}
+
+// StopAll stops every receiver concurrently, so the shutdown timeout
+// doesn't have to cover one escalation per receiver.
+func (m *Manager) StopAll(cfg config.Op25Config) {
+    var wg sync.WaitGroup
+    for _, r := range m.ordered {
+        wg.Add(1)
+        go func(r *Receiver) {
+            defer wg.Done()
+            r.Stop(cfg)
+        }(r)
+    }
+    wg.Wait()
+}
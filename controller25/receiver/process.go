@@ -0,0 +1,128 @@
+package receiver
+
+import (
+    "fmt"
+    "log"
+    "os"
+    "os/exec"
+    "sync/atomic"
+    "syscall"
+    "time"
+
+    "controller25/config"
+    "controller25/metrics"
+)
+
+// process tracks one OP25 child from start until it has been reaped.
+type process struct {
+    cmd    *exec.Cmd
+    exited chan struct{} // closed once the process has been reaped
+    state  *os.ProcessState
+    err    error
+    // Set before the controller signals the process, so the exit isn't
+    // counted as a crash.
+    stopping atomic.Bool
+}
+
+// watch starts reaping cmd in the background, counting unrequested exits
+// in crashes. It uses Process.Wait rather than Cmd.Wait, which would close
+// the stdout/stderr pipes and could cut off the last lines (usually the
+// traceback) of a crashing rx.py; the log broadcaster closes the pipes
+// itself once it has read them to EOF.
+func watch(cmd *exec.Cmd, id string, crashes *metrics.Counter) *process {
+    p := &process{cmd: cmd, exited: make(chan struct{})}
+    go func() {
+        p.state, p.err = cmd.Process.Wait()
+        if !p.stopping.Load() {
+            crashes.Inc()
+            if p.err != nil {
+                log.Printf("OP25 process %d (receiver %s) could not be waited for: %v", cmd.Process.Pid, id, p.err)
+            } else {
+                log.Printf("OP25 process %d (receiver %s) exited unexpectedly: %s", cmd.Process.Pid, id, p.state)
+            }
+        }
+        close(p.exited)
+    }()
+    return p
+}
+
+// alive reports whether the process has not exited yet.
+func (p *process) alive() bool {
+    select {
+    case <-p.exited:
+        return false
+    default:
+        return true
+    }
+}
+
+// stop ends the process group, escalating from SIGINT to SIGTERM to SIGKILL
+// as each timeout passes, and describes how the process ended. It blocks
+// until the process has been reaped and must not be called with the
+// receiver's mu held.
+func (p *process) stop(cfg config.Op25Config) string {
+    p.stopping.Store(true)
+    pgid := p.cmd.Process.Pid
+    steps := []struct {
+        sig     syscall.Signal
+        timeout time.Duration
+    }{
+        {syscall.SIGINT, cfg.InterruptTimeout},
+        {syscall.SIGTERM, cfg.TerminateTimeout},
+    }
+
+    var sent syscall.Signal
+    for _, step := range steps {
+        if step.timeout <= 0 || !p.alive() {
+            continue
+        }
+        log.Printf("Sending %s to OP25 process group %d", signalName(step.sig), pgid)
+        if err := syscall.Kill(-pgid, step.sig); err != nil {
+            log.Printf("Failed to send %s to OP25: %v", signalName(step.sig), err)
+            continue
+        }
+        sent = step.sig
+        select {
+        case <-p.exited:
+        case <-time.After(step.timeout):
+            log.Printf("OP25 still running %s after %s", step.timeout, signalName(step.sig))
+        }
+    }
+    if p.alive() {
+        sent = syscall.SIGKILL
+        log.Printf("Sending SIGKILL to OP25 process group %d", pgid)
+        syscall.Kill(-pgid, syscall.SIGKILL)
+        <-p.exited
+    }
+    // Clean up any children left behind in the group; ESRCH means none
+    syscall.Kill(-pgid, syscall.SIGKILL)
+
+    return p.describeExit(sent)
+}
+
+// describeExit reports the signal that ended the process, or its exit
+// status if it exited on its own after sent.
+func (p *process) describeExit(sent syscall.Signal) string {
+    if p.state == nil {
+        return fmt.Sprintf("unknown (%v)", p.err)
+    }
+    if ws, ok := p.state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+        return signalName(ws.Signal())
+    }
+    if sent == 0 {
+        return p.state.String()
+    }
+    return fmt.Sprintf("%s (%s)", signalName(sent), p.state)
+}
+
+func signalName(sig syscall.Signal) string {
+    switch sig {
+    case syscall.SIGINT:
+        return "SIGINT"
+    case syscall.SIGTERM:
+        return "SIGTERM"
+    case syscall.SIGKILL:
+        return "SIGKILL"
+    }
+    return sig.String()
+}
@@ -0,0 +1,273 @@
+package receiver
+
+import (
+    "log"
+    "net/http"
+    "sync"
+    "syscall"
+    "time"
+
+    "controller25/audio"
+    "controller25/config"
+    "controller25/health"
+    "controller25/log"
+    "controller25/metrics"
+    "controller25/terminal"
+)
+
+// Receiver is one OP25 instance and everything attached to it while it
+// runs: the audio and log broadcasters and, when rx.py has an HTTP terminal,
+// the proxy, command client and state poller.
+type Receiver struct {
+    ID      string
+    Profile config.ReceiverConfig
+
+    // Count over the controller's lifetime, across restarts of OP25
+    Starts  metrics.Counter
+    Crashes metrics.Counter
+
+    mu             sync.Mutex
+    process        *process
+    running        bool
+    stopping       bool
+    flags          []string
+    resources      config.Resources
+    startedAt      time.Time
+    audio          *audio.Broadcaster
+    logs           *logstream.Broadcaster
+    terminalProxy  http.Handler
+    terminalClient *terminal.Client
+    poller         *terminal.Poller
+    // Serializes start and stop, which can take seconds; held without mu so
+    // status requests keep answering meanwhile.
+    lifecycle sync.Mutex
+}
+
+// Status is a snapshot of whether the receiver runs and with which flags.
+type Status struct {
+    Mode     string
+    Running  bool
+    Stopping bool
+    Flags    []string
+}
+
+func New(profile config.ReceiverConfig) *Receiver {
+    return &Receiver{ID: profile.ID, Profile: profile}
+}
+
+// Start starts OP25 with flags (see Flags), stopping it first if it is
+// already running. Conventional and multi_rx receivers run multi_rx.py
+// instead of rx.py; the former only take the SDR from flags, the latter
+// ignore them.
+func (r *Receiver) Start(cfg *config.Config, flags []string) error {
+    r.lifecycle.Lock()
+    defer r.lifecycle.Unlock()
+    r.mu.Lock()
+    running := r.running
+    r.mu.Unlock()
+    if running {
+        r.stop(cfg.Op25)
+    }
+
+    p, err := cfg.ReceiverProcess(r.Profile)
+    if err != nil {
+        return err
+    }
+    var l launch
+    switch r.Profile.Mode {
+    case config.ModeConventional:
+        l, err = r.startConventional(cfg, p, Flags(r.Profile, flags))
+    case config.ModeMultiRx:
+        l, err = r.startMultiRx(cfg, p)
+    default:
+        l, err = r.startRx(cfg, p, Flags(r.Profile, flags))
+    }
+    if err != nil {
+        return err
+    }
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.Starts.Inc()
+    r.process = watch(l.cmd, r.ID, &r.Crashes)
+    r.running = true
+    r.flags = l.flags
+    r.resources = p.Resources
+    r.startedAt = time.Now()
+    if addr := l.terminalAddr; addr != "" {
+        r.terminalProxy = terminal.NewProxy(addr)
+        r.terminalClient = terminal.NewClient(addr)
+        r.poller = terminal.NewPoller(r.terminalClient, cfg.Op25.PollInterval)
+        r.poller.Start()
+    }
+
+    r.audio = l.audio
+    r.logs = logstream.NewBroadcaster(l.stdout, l.stderr, cfg.Logs)
+    go r.logs.Start()
+    return nil
+}
+
+// Stop stops OP25 and tears down everything attached to it, returning how
+// the process ended ("" if it wasn't running).
+func (r *Receiver) Stop(cfg config.Op25Config) string {
+    r.lifecycle.Lock()
+    defer r.lifecycle.Unlock()
+    return r.stop(cfg)
+}
+
+// stop is Stop for callers already holding lifecycle.
+func (r *Receiver) stop(cfg config.Op25Config) string {
+    r.mu.Lock()
+    process := r.process
+    r.stopping = process != nil
+    r.mu.Unlock()
+
+    stoppedBy := ""
+    if process != nil {
+        log.Printf("Terminating OP25 process (receiver %s)...", r.ID)
+        stoppedBy = process.stop(cfg)
+        log.Printf("OP25 process (receiver %s) terminated: %s", r.ID, stoppedBy)
+    }
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.stopping = false
+    r.running = false
+    r.flags = nil
+    r.startedAt = time.Time{}
+    r.process = nil
+    r.terminalProxy = nil
+    r.terminalClient = nil
+    if r.poller != nil {
+        r.poller.Shutdown()
+        r.poller = nil
+    }
+    if r.audio != nil {
+        r.audio.Shutdown()
+        r.audio = nil
+    }
+    if r.logs != nil {
+        r.logs.Close()
+        r.logs = nil
+    }
+    return stoppedBy
+}
+
+// Running reports whether OP25 was started and has not been stopped. It
+// stays true after a crash until the receiver is stopped or restarted.
+func (r *Receiver) Running() bool {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.running && r.process != nil
+}
+
+func (r *Receiver) Status() Status {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return Status{Mode: r.Profile.Mode, Running: r.running, Stopping: r.stopping, Flags: r.flags}
+}
+
+// Resources returns the priorities and limits the OP25 process was started
+// with and what it actually runs with, read back from the kernel. ok is
+// false while OP25 isn't running.
+func (r *Receiver) Resources() (configured config.Resources, effective config.EffectiveResources, ok bool, err error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if !r.running || r.process == nil || !r.process.alive() {
+        return config.Resources{}, config.EffectiveResources{}, false, nil
+    }
+    effective, err = config.ReadResources(r.process.cmd.Process.Pid)
+    return r.resources, effective, true, err
+}
+
+// Audio returns the audio broadcaster, or nil while OP25 is stopped.
+func (r *Receiver) Audio() *audio.Broadcaster {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.audio
+}
+
+// Logs returns the log broadcaster, or nil while OP25 is stopped.
+func (r *Receiver) Logs() *logstream.Broadcaster {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.logs
+}
+
+// Terminal returns the rx.py HTTP terminal proxy, command client and state
+// poller, all nil unless OP25 runs with -l http:...
+func (r *Receiver) Terminal() (http.Handler, *terminal.Client, *terminal.Poller) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.terminalProxy, r.terminalClient, r.poller
+}
+
+// Sources gathers the receiver's live state for health checks and metrics.
+func (r *Receiver) Sources() health.Sources {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    src := health.Sources{
+        Op25Running:   r.running && r.process != nil && r.process.alive(),
+        Op25StartedAt: r.startedAt,
+    }
+    if r.process != nil {
+        src.Op25PID = r.process.cmd.Process.Pid
+    }
+    if r.audio != nil {
+        stats := r.audio.Stats()
+        src.AudioPackets, src.AudioBytes, src.LastAudio, src.AudioClients = stats.Packets, stats.Bytes, stats.LastPacket, stats.Clients
+        src.AudioOddPackets, src.AudioDropped, src.AudioHeld = stats.OddPackets, stats.Dropped, stats.Held
+    }
+    if r.logs != nil {
+        stats := r.logs.Stats()
+        for _, n := range stats.Lines {
+            src.LogLines += n
+        }
+        src.LogLinesByStream = stats.Lines
+        src.LastLogLine, src.LogClients = stats.LastLine, stats.Clients
+    }
+    if r.poller != nil {
+        src.StateClients = r.poller.Clients()
+        src.LastControl = r.poller.LastControl()
+    }
+    return src
+}
+
+// Apply applies the live settings of a reloaded configuration to the
+// running broadcasters.
+func (r *Receiver) Apply(cfg *config.Config) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.audio != nil {
+        r.audio.SetLimits(cfg.Audio.MaxClients, cfg.Audio.ClientBuffer)
+    }
+    if r.logs != nil {
+        r.logs.SetHistoryLines(cfg.Logs.HistoryLines)
+    }
+}
+
+// CloseStreams ends the audio, log and state streams of connected clients,
+// leaving OP25 running.
+func (r *Receiver) CloseStreams() {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.logs != nil {
+        r.logs.Close()
+    }
+    if r.poller != nil {
+        r.poller.Shutdown()
+    }
+    if r.audio != nil {
+        r.audio.Shutdown()
+    }
+}
+
+// Kill sends SIGKILL to the OP25 process group without waiting, for when a
+// clean stop ran out of time.
+func (r *Receiver) Kill() {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.process != nil && r.process.alive() {
+        syscall.Kill(-r.process.cmd.Process.Pid, syscall.SIGKILL)
+    }
+}
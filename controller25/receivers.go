@@ -0,0 +1,229 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+
+    "controller25/config"
+    "controller25/mdns"
+    "controller25/receiver"
+)
+
+// receivers holds every configured OP25 instance, set up before serving.
+var receivers *receiver.Manager
+
+// receiverHandler handles a request for one receiver.
+type receiverHandler func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver)
+
+// handleReceiver registers handler on the legacy route, where it acts on the
+// default receiver, and on the scoped route, where {id} picks the receiver.
+func handleReceiver(legacy, scoped string, handler receiverHandler) {
+    h := func(w http.ResponseWriter, r *http.Request) {
+        rx, ok := lookupReceiver(r)
+        if !ok {
+            http.Error(w, "Unknown receiver", http.StatusNotFound)
+            return
+        }
+        handler(w, r, rx)
+    }
+    http.HandleFunc(legacy, h)
+    http.HandleFunc(scoped, h)
+}
+
+// lookupReceiver returns the receiver named by the {id} path value, or the
+// default receiver on unscoped routes.
+func lookupReceiver(r *http.Request) (*receiver.Receiver, bool) {
+    id := r.PathValue("id")
+    if id == "" {
+        return receivers.Default(), true
+    }
+    return receivers.Get(id)
+}
+
+// op25TXT is the "op25" TXT value: running while any receiver runs.
+func op25TXT() string {
+    if receivers.AnyRunning() {
+        return "running"
+    }
+    return "stopped"
+}
+
+func receiverStatus(rx *receiver.Receiver) Op25StatusResponse {
+    status := rx.Status()
+    resp := Op25StatusResponse{
+        ID:          rx.ID,
+        Description: rx.Profile.Description,
+        Mode:        status.Mode,
+        Running:     status.Running,
+        Stopping:    status.Stopping,
+        Flags:       status.Flags,
+    }
+    configured, effective, ok, err := rx.Resources()
+    switch {
+    case err != nil:
+        resp.ResourceWarnings = []string{err.Error()}
+    case ok:
+        resp.Resources = &effective
+        resp.ResourceWarnings = configured.Mismatches(effective)
+    }
+    return resp
+}
+
+func registerReceiverHandlers(mdnsService *mdns.Service) {
+    http.HandleFunc("/api/receivers", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        resp := []Op25StatusResponse{}
+        for _, rx := range receivers.All() {
+            resp = append(resp, receiverStatus(rx))
+        }
+        _ = json.NewEncoder(w).Encode(resp)
+    })
+
+    handleReceiver("/audio.wav", "/receivers/{id}/audio.wav", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        audioBroadcaster := rx.Audio()
+        if audioBroadcaster == nil {
+            http.Error(w, "Audio not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
+            return
+        }
+        audioBroadcaster.ServeWAV(w, r)
+    })
+    handleReceiver("/stream", "/receivers/{id}/stream", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        logBroadcaster := rx.Logs()
+        if logBroadcaster == nil {
+            http.Error(w, "Logs not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
+            return
+        }
+        logBroadcaster.ServeSSE(w, r)
+    })
+
+    // Reverse proxy to rx.py's HTTP terminal, so clients only need the controller port
+    handleReceiver("/op25/", "/receivers/{id}/op25/", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        proxy, _, _ := rx.Terminal()
+        if proxy == nil {
+            http.Error(w, "OP25 HTTP terminal not available (OP25 not started with -l http:...)", http.StatusServiceUnavailable)
+            return
+        }
+        prefix := "/op25"
+        if id := r.PathValue("id"); id != "" {
+            prefix = "/receivers/" + id + "/op25"
+        }
+        http.StripPrefix(prefix, proxy).ServeHTTP(w, r)
+    })
+
+    // Cached rx.py state, polled once by the controller and pushed to clients
+    handleReceiver("/api/state", "/api/receivers/{id}/state", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        _, _, poller := rx.Terminal()
+        if poller == nil {
+            http.Error(w, "State not available (OP25 not started with -l http:...)", http.StatusServiceUnavailable)
+            return
+        }
+        poller.ServeSSE(w, r)
+    })
+    handleReceiver("/api/state/snapshot", "/api/receivers/{id}/state/snapshot", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        _, _, poller := rx.Terminal()
+        if poller == nil {
+            http.Error(w, "State not available (OP25 not started with -l http:...)", http.StatusServiceUnavailable)
+            return
+        }
+        poller.ServeSnapshot(w, r)
+    })
+
+    handleReceiver("/api/op25/start", "/api/receivers/{id}/start", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        var req Op25StartRequest
+        // An empty body starts the receiver with its profile's flags
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+            http.Error(w, "Invalid request body", http.StatusBadRequest)
+            return
+        }
+
+        // If already running, Start shuts down and restarts
+        err := rx.Start(currentConfig.Load(), req.Flags)
+        mdnsService.Set(map[string]string{"op25": op25TXT()})
+        if err != nil {
+            resp := Op25StartResponse{Started: false, Error: err.Error()}
+            _ = json.NewEncoder(w).Encode(resp)
+            return
+        }
+        resp := Op25StartResponse{Started: true}
+        _ = json.NewEncoder(w).Encode(resp)
+    })
+
+    handleReceiver("/api/op25/stop", "/api/receivers/{id}/stop", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        if !rx.Running() {
+            w.WriteHeader(http.StatusConflict)
+            _ = json.NewEncoder(w).Encode(Op25StartResponse{Started: false, Error: "OP25 not running"})
+            return
+        }
+        stoppedBy := rx.Stop(currentConfig.Load().Op25)
+        mdnsService.Set(map[string]string{"op25": op25TXT()})
+        _ = json.NewEncoder(w).Encode(Op25StartResponse{Started: false, StoppedBy: stoppedBy})
+    })
+
+    handleReceiver("/api/op25/status", "/api/receivers/{id}/status", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        _ = json.NewEncoder(w).Encode(receiverStatus(rx))
+    })
+
+    // Trunk file read endpoint
+    handleReceiver("/api/trunk/read", "/api/receivers/{id}/trunk/read", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        sys, err := config.ReadTrunkSystem(rx.Profile.TrunkFile)
+        if err != nil {
+            _ = json.NewEncoder(w).Encode(TrunkReadResponse{Error: err.Error()})
+            return
+        }
+        _ = json.NewEncoder(w).Encode(TrunkReadResponse{
+            SysName:        sys.SysName,
+            ControlChannel: sys.ControlChannel,
+            NAC:            sys.NAC,
+            Modulation:     sys.Modulation,
+            TagsFile:       sys.TagsFile,
+        })
+    })
+
+    // Trunk file write endpoint
+    handleReceiver("/api/trunk/write", "/api/receivers/{id}/trunk/write", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        var req TrunkWriteRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            _ = json.NewEncoder(w).Encode(TrunkWriteResponse{Success: false, Error: "Invalid request body"})
+            return
+        }
+        // Keep the columns the request doesn't cover, such as an imported
+        // tags file
+        sys := &config.TrunkSystem{}
+        if existing, err := config.ReadTrunkSystem(rx.Profile.TrunkFile); err == nil {
+            sys = existing
+        }
+        sys.SysName = req.SysName
+        sys.ControlChannel = req.ControlChannel
+        err := config.WriteTrunkSystem(rx.Profile.TrunkFile, sys)
+        if err != nil {
+            _ = json.NewEncoder(w).Encode(TrunkWriteResponse{Success: false, Error: err.Error()})
+            return
+        }
+        // The TXT record advertises the default receiver's system
+        if rx.ID == config.DefaultReceiver {
+            mdnsService.Set(map[string]string{"sys": sys.SysName})
+        }
+        _ = json.NewEncoder(w).Encode(TrunkWriteResponse{Success: true})
+    })
+}
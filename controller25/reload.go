@@ -0,0 +1,73 @@
+package main
+
+import (
+    "log"
+    "sync"
+    "sync/atomic"
+
+    "controller25/auth"
+    "controller25/config"
+    "controller25/health"
+    "controller25/mdns"
+)
+
+// currentConfig is the configuration in effect. Handlers load it on every
+// request so a reload applies without restarting them.
+var currentConfig atomic.Pointer[config.Config]
+
+var reloadMu sync.Mutex
+
+// reloadConfig re-reads config.ini and applies what can change live: auth
+// credentials and the mDNS TXT record advertising them, stream limits,
+// history sizes and health thresholds. Settings that need OP25 or the
+// controller to restart are only reported. On error the current
+// configuration stays in effect.
+func reloadConfig(authenticator *auth.Authenticator, mdnsService *mdns.Service) (config.Changes, error) {
+    reloadMu.Lock()
+    defer reloadMu.Unlock()
+
+    current := currentConfig.Load()
+    next, err := config.Load(current.File)
+    if err != nil {
+        return config.Changes{}, err
+    }
+    next, changes := current.Reload(next)
+    currentConfig.Store(next)
+
+    authenticator.Update(next.Auth)
+    mdnsService.Set(map[string]string{"auth": authMode(authenticator)})
+    auditLog.Resize(next.Logs.AuditEntries)
+    healthChecker.SetThresholds(healthThresholds(next.Health))
+    for _, rx := range receivers.All() {
+        rx.Apply(next)
+    }
+
+    log.Printf("Configuration reloaded from %s", next.File)
+    if len(changes.Applied) > 0 {
+        log.Printf("Applied: %v", changes.Applied)
+    }
+    if len(changes.Op25Restart) > 0 {
+        log.Printf("Takes effect when OP25 restarts: %v", changes.Op25Restart)
+    }
+    if len(changes.Restart) > 0 {
+        log.Printf("Needs a controller25 restart, still using the old values: %v", changes.Restart)
+    }
+    return changes, nil
+}
+
+// healthChecker serves /health and /health/ready; reloads update its
+// thresholds.
+var healthChecker *health.Checker
+
+// healthThresholds is when the health checker reports OP25 as not ready.
+func healthThresholds(cfg config.HealthConfig) health.Thresholds {
+    return health.Thresholds{Audio: cfg.AudioStaleAfter, Activity: cfg.ActivityStaleAfter}
+}
+
+// authMode is the "auth" TXT value.
+func authMode(authenticator *auth.Authenticator) string {
+    if authenticator.Enabled() {
+        return "bearer"
+    }
+    return "none"
+}
@@ -0,0 +1,135 @@
+package sdr
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os/exec"
+    "regexp"
+    "strconv"
+    "strings"
+
+    "controller25/config"
+)
+
+// The RTL2832U resamples to any rate in 225001-300000 and 900001-3200000
+// S/s; these are the ones SoapyRTLSDR offers. Above 2.4 MS/s most dongles
+// drop samples.
+var rtlSampleRates = []float64{250000, 1024000, 1536000, 1792000, 1920000, 2048000, 2160000, 2400000, 2560000, 2880000, 3200000}
+
+// "  0:  Realtek, RTL2838UHIDIR, SN: 00000001"
+var rtlDeviceLine = regexp.MustCompile(`^\s*(\d+):\s+(.*?)(?:,?\s*SN:\s*(\S*))?\s*$`)
+
+// scanRTL lists dongles with rtl_test, which also reports the tuner and gain
+// steps of the device it opens, and reads USB IDs with rtl_eeprom.
+func scanRTL(ctx context.Context, cfg config.SDRConfig, claims []Claim) ([]Device, error) {
+    out, err := run(ctx, cfg, cfg.RTLTest, "-d", "0", "-t")
+    devices := parseRTLList(out)
+    var execErr *exec.Error
+    if errors.As(err, &execErr) || len(devices) == 0 && err != nil && !strings.Contains(out, "No supported devices") {
+        return nil, fmt.Errorf("%s: %v", cfg.RTLTest, err)
+    }
+
+    for i := range devices {
+        d := &devices[i]
+        d.SampleRates = rtlSampleRates
+        d.Args = "rtl=" + strconv.Itoa(d.Index)
+        if d.Serial != "" {
+            d.Args = "rtl=" + d.Serial
+        }
+        if d.Receiver = claimedBy(*d, devices, claims); d.Receiver != "" {
+            d.Error = "in use"
+            continue
+        }
+
+        if d.Index != 0 {
+            out, _ = run(ctx, cfg, cfg.RTLTest, "-d", strconv.Itoa(d.Index), "-t")
+        }
+        d.Tuner, d.Gains = parseRTLTuner(out)
+        if d.Tuner == "" {
+            d.Error = lastLine(out)
+        }
+        if cfg.RTLEEPROM != "" {
+            eeprom, _ := run(ctx, cfg, cfg.RTLEEPROM, "-d", strconv.Itoa(d.Index))
+            parseRTLEEPROM(eeprom, d)
+        }
+    }
+    return devices, nil
+}
+
+// parseRTLList parses the "Found N device(s):" list librtlsdr tools print.
+func parseRTLList(out string) []Device {
+    var devices []Device
+    inList := false
+    for _, line := range strings.Split(out, "\n") {
+        if strings.HasPrefix(line, "Found ") && strings.Contains(line, "device(s)") {
+            inList = true
+            continue
+        }
+        if !inList {
+            continue
+        }
+        m := rtlDeviceLine.FindStringSubmatch(line)
+        if m == nil {
+            break
+        }
+        index, _ := strconv.Atoi(m[1])
+        d := Device{Driver: "rtlsdr", Index: index, Serial: m[3]}
+        // "Realtek, RTL2838UHIDIR"; rtl_eeprom prints the product name only
+        if manufacturer, product, ok := strings.Cut(m[2], ", "); ok {
+            d.Manufacturer, d.Product = manufacturer, product
+        } else {
+            d.Product = m[2]
+        }
+        devices = append(devices, d)
+    }
+    return devices
+}
+
+// parseRTLTuner parses "Found Rafael Micro R820T tuner" and
+// "Supported gain values (29): 0.0 0.9 ...".
+func parseRTLTuner(out string) (tuner string, gains []float64) {
+    for _, line := range strings.Split(out, "\n") {
+        line = strings.TrimSpace(line)
+        if strings.HasPrefix(line, "Found ") && strings.HasSuffix(line, " tuner") {
+            tuner = strings.TrimSuffix(strings.TrimPrefix(line, "Found "), " tuner")
+        }
+        if _, values, ok := strings.Cut(line, "Supported gain values"); ok {
+            if _, list, ok := strings.Cut(values, ":"); ok {
+                gains = parseFloats(strings.Fields(list))
+            }
+        }
+    }
+    return tuner, gains
+}
+
+// parseRTLEEPROM fills in the USB IDs and names from rtl_eeprom's
+// "Current configuration" block.
+func parseRTLEEPROM(out string, d *Device) {
+    for _, line := range strings.Split(out, "\n") {
+        key, value, ok := strings.Cut(line, ":")
+        if !ok {
+            continue
+        }
+        value = strings.TrimSpace(value)
+        switch strings.TrimSpace(key) {
+        case "Vendor ID":
+            d.VendorID = value
+        case "Product ID":
+            d.ProductID = value
+        case "Manufacturer":
+            d.Manufacturer = value
+        case "Product":
+            d.Product = value
+        case "Serial number":
+            if d.Serial == "" {
+                d.Serial = value
+            }
+        }
+    }
+}
+
+func lastLine(out string) string {
+    lines := strings.Split(strings.TrimSpace(out), "\n")
+    return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,163 @@
+package sdr
+
+import (
+    "context"
+    "os/exec"
+    "strconv"
+    "strings"
+    "sync"
+
+    "controller25/config"
+)
+
+// Device is one attached SDR.
+type Device struct {
+    Driver       string    `json:"driver"` // "rtlsdr" or a SoapySDR driver
+    Index        int       `json:"index"`  // among devices of the same driver
+    Serial       string    `json:"serial,omitempty"`
+    Manufacturer string    `json:"manufacturer,omitempty"`
+    Product      string    `json:"product,omitempty"`
+    VendorID     string    `json:"vendor_id,omitempty"`
+    ProductID    string    `json:"product_id,omitempty"`
+    Tuner        string    `json:"tuner,omitempty"`
+    Gains        []float64 `json:"gains,omitempty"`      // dB, discrete steps
+    GainRange    []float64 `json:"gain_range,omitempty"` // dB, [min, max]
+    SampleRates  []float64 `json:"sample_rates,omitempty"`
+    Args         string    `json:"args"`               // rx.py --args selecting this device
+    Receiver     string    `json:"receiver,omitempty"` // running receiver using it
+    Error        string    `json:"error,omitempty"`    // why details are missing
+}
+
+// Claim is a running receiver and the --args it was started with.
+type Claim struct {
+    Receiver string
+    Args     string
+}
+
+// Devices in use can't be opened by the probing tools, and two probes can't
+// open the same device either.
+var scanMu sync.Mutex
+
+// Scan lists the attached SDRs and marks the ones claimed by a running
+// receiver. Claimed devices aren't probed, so they lack tuner, gain and
+// sample rate details. A failing tool is reported in errs without hiding the
+// devices found by the others.
+func Scan(ctx context.Context, cfg config.SDRConfig, claims []Claim) (devices []Device, errs []error) {
+    scanMu.Lock()
+    defer scanMu.Unlock()
+
+    if cfg.RTLTest != "" {
+        rtl, err := scanRTL(ctx, cfg, claims)
+        if err != nil {
+            errs = append(errs, err)
+        }
+        devices = append(devices, rtl...)
+    }
+    if cfg.SoapyUtil != "" {
+        // SoapyRTLSDR lists the dongles rtl_test already found
+        skipRTL := len(devices) > 0
+        soapy, err := scanSoapy(ctx, cfg, claims, skipRTL)
+        if err != nil {
+            errs = append(errs, err)
+        }
+        devices = append(devices, soapy...)
+    }
+    if devices == nil {
+        devices = []Device{}
+    }
+    return devices, errs
+}
+
+// ArgsFromFlags returns the value of rx.py's --args in flags, unquoted.
+func ArgsFromFlags(flags []string) string {
+    var value string
+    for i, flag := range flags {
+        switch {
+        case flag == "--args" && i+1 < len(flags):
+            value = flags[i+1]
+        case strings.HasPrefix(flag, "--args="):
+            value = strings.TrimPrefix(flag, "--args=")
+        }
+    }
+    return strings.Trim(value, `'"`)
+}
+
+// claimedBy returns the receiver whose --args select d, or "". devices are
+// all devices of d's driver, needed to tell serials from indexes the way
+// gr-osmosdr does: a value is a serial if any device has it.
+func claimedBy(d Device, devices []Device, claims []Claim) string {
+    for _, claim := range claims {
+        args := parseArgs(claim.Args)
+        key := d.Driver
+        if d.Driver == "rtlsdr" {
+            key = "rtl"
+        }
+        if driver, ok := args["driver"]; ok {
+            // soapy=N,driver=X[,serial=Y]
+            if driver != d.Driver || args["serial"] != "" && args["serial"] != d.Serial {
+                continue
+            }
+            if args["serial"] != "" || atoiOr(args["soapy"], 0) == d.Index {
+                return claim.Receiver
+            }
+            continue
+        }
+        value, ok := args[key]
+        if !ok {
+            continue
+        }
+        if value == d.Serial && value != "" {
+            return claim.Receiver
+        }
+        if !hasSerial(devices, value) && atoiOr(value, 0) == d.Index {
+            return claim.Receiver
+        }
+    }
+    return ""
+}
+
+func hasSerial(devices []Device, serial string) bool {
+    for _, d := range devices {
+        if serial != "" && d.Serial == serial {
+            return true
+        }
+    }
+    return false
+}
+
+// parseArgs splits osmosdr device arguments such as "rtl=0,buflen=4096".
+// A key without a value maps to "".
+func parseArgs(args string) map[string]string {
+    parsed := make(map[string]string)
+    for _, part := range strings.FieldsFunc(args, func(r rune) bool { return r == ',' || r == ' ' }) {
+        key, value, _ := strings.Cut(part, "=")
+        parsed[key] = value
+    }
+    return parsed
+}
+
+func atoiOr(s string, fallback int) int {
+    if n, err := strconv.Atoi(s); err == nil {
+        return n
+    }
+    return fallback
+}
+
+// run runs a tool and returns what it printed on stdout and stderr, which
+// is still parsed when the tool fails or is killed by the timeout.
+func run(ctx context.Context, cfg config.SDRConfig, name string, args ...string) (string, error) {
+    ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+    defer cancel()
+    out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+    return string(out), err
+}
+
+func parseFloats(fields []string) []float64 {
+    var values []float64
+    for _, field := range fields {
+        if v, err := strconv.ParseFloat(strings.Trim(field, "[],"), 64); err == nil {
+            values = append(values, v)
+        }
+    }
+    return values
+}
@@ -0,0 +1,115 @@
+package sdr
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os/exec"
+    "strings"
+
+    "controller25/config"
+)
+
+// scanSoapy lists devices with SoapySDRUtil --find and probes the ones not
+// in use for gain and sample rate ranges. skipRTL leaves out rtlsdr devices,
+// for when rtl_test already listed them.
+func scanSoapy(ctx context.Context, cfg config.SDRConfig, claims []Claim, skipRTL bool) ([]Device, error) {
+    out, err := run(ctx, cfg, cfg.SoapyUtil, "--find")
+    var execErr *exec.Error
+    if errors.As(err, &execErr) {
+        return nil, fmt.Errorf("%s: %v", cfg.SoapyUtil, err)
+    }
+
+    var devices []Device
+    perDriver := make(map[string]int)
+    for _, found := range parseSoapyFind(out) {
+        driver := found["driver"]
+        if driver == "" || skipRTL && driver == "rtlsdr" {
+            continue
+        }
+        d := Device{
+            Driver:       driver,
+            Index:        perDriver[driver],
+            Serial:       found["serial"],
+            Manufacturer: found["manufacturer"],
+            Product:      found["product"],
+            Tuner:        found["tuner"],
+        }
+        if d.Product == "" {
+            d.Product = found["label"]
+        }
+        perDriver[driver]++
+        d.Args = fmt.Sprintf("soapy=%d,driver=%s", d.Index, driver)
+        if d.Serial != "" {
+            d.Args += ",serial=" + d.Serial
+        }
+        devices = append(devices, d)
+    }
+
+    for i := range devices {
+        d := &devices[i]
+        if d.Receiver = claimedBy(*d, devices, claims); d.Receiver != "" {
+            d.Error = "in use"
+            continue
+        }
+        probe := "driver=" + d.Driver
+        if d.Serial != "" {
+            probe += ",serial=" + d.Serial
+        }
+        out, err := run(ctx, cfg, cfg.SoapyUtil, "--probe="+probe)
+        d.GainRange, d.SampleRates = parseSoapyProbe(out)
+        if err != nil && d.SampleRates == nil {
+            d.Error = lastLine(out)
+        }
+    }
+    if err != nil && len(devices) == 0 {
+        return nil, fmt.Errorf("%s --find: %v", cfg.SoapyUtil, err)
+    }
+    return devices, nil
+}
+
+// parseSoapyFind parses the "Found device N" blocks of key = value lines.
+func parseSoapyFind(out string) []map[string]string {
+    var found []map[string]string
+    for _, line := range strings.Split(out, "\n") {
+        if strings.HasPrefix(line, "Found device") {
+            found = append(found, make(map[string]string))
+            continue
+        }
+        key, value, ok := strings.Cut(line, " = ")
+        if !ok || len(found) == 0 {
+            continue
+        }
+        found[len(found)-1][strings.TrimSpace(key)] = strings.TrimSpace(value)
+    }
+    return found
+}
+
+// parseSoapyProbe reads the first RX channel's "Full gain range: [0, 49.6] dB"
+// and "Sample rates: 0.25, 1.024 MSps" (or a "[min, max] MSps" range).
+func parseSoapyProbe(out string) (gainRange, sampleRates []float64) {
+    for _, line := range strings.Split(out, "\n") {
+        key, value, ok := strings.Cut(strings.TrimSpace(line), ": ")
+        if !ok {
+            continue
+        }
+        switch key {
+        case "Full gain range":
+            if gainRange == nil {
+                gainRange = parseFloats(strings.Fields(strings.TrimSuffix(value, " dB")))
+            }
+        case "Sample rates":
+            if sampleRates == nil {
+                scale := 1e6
+                if strings.HasSuffix(value, " kSps") {
+                    scale = 1e3
+                }
+                value = strings.TrimSuffix(strings.TrimSuffix(value, " MSps"), " kSps")
+                for _, rate := range parseFloats(strings.Fields(value)) {
+                    sampleRates = append(sampleRates, rate*scale)
+                }
+            }
+        }
+    }
+    return gainRange, sampleRates
+}
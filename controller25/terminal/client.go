@@ -0,0 +1,88 @@
+package terminal
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// Command is a single request to the rx.py HTTP terminal.
+type Command struct {
+    Command string      `json:"command"`
+    Arg1    interface{} `json:"arg1"`
+    Arg2    interface{} `json:"arg2"`
+}
+
+// Message is one entry of an rx.py terminal response. JSONType is the
+// "json_type" key ("trunk_update", "channel_update", ...), Data the full object.
+type Message struct {
+    JSONType string
+    Data     json.RawMessage
+}
+
+// Client talks to the rx.py HTTP terminal. rx.py handles requests one at a
+// time, so all commands sent through a Client are serialized.
+type Client struct {
+    url  string
+    http *http.Client
+    mu   sync.Mutex
+}
+
+func NewClient(addr string) *Client {
+    return &Client{
+        url:  "http://" + addr + "/",
+        http: &http.Client{Timeout: 2 * time.Second},
+    }
+}
+
+// Send posts cmds to rx.py in a single request and returns the decoded messages.
+func (c *Client) Send(cmds ...Command) ([]Message, error) {
+    body, err := json.Marshal(cmds)
+    if err != nil {
+        return nil, err
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    resp, err := c.http.Post(c.url, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("rx.py terminal returned %s", resp.Status)
+    }
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    if len(bytes.TrimSpace(data)) == 0 {
+        return nil, nil
+    }
+
+    var raw []json.RawMessage
+    if err := json.Unmarshal(data, &raw); err != nil {
+        return nil, fmt.Errorf("invalid rx.py terminal response: %v", err)
+    }
+    msgs := make([]Message, 0, len(raw))
+    for _, item := range raw {
+        var head struct {
+            JSONType string `json:"json_type"`
+        }
+        if err := json.Unmarshal(item, &head); err != nil || head.JSONType == "" {
+            continue
+        }
+        // SSE payloads must fit on one line
+        var compact bytes.Buffer
+        if err := json.Compact(&compact, item); err != nil {
+            continue
+        }
+        msgs = append(msgs, Message{JSONType: head.JSONType, Data: compact.Bytes()})
+    }
+    return msgs, nil
+}
@@ -0,0 +1,242 @@
+package terminal
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// cachedTypes are the rx.py message types kept in the state cache.
+var cachedTypes = map[string]bool{
+    "trunk_update":    true,
+    "channel_update":  true,
+    "call_log":        true,
+    "rx_update":       true,
+    "terminal_config": true,
+    "full_config":     true,
+}
+
+// Snapshot is the complete cached rx.py state.
+type Snapshot struct {
+    Updated time.Time                  `json:"updated"`
+    Error   string                     `json:"error,omitempty"`
+    State   map[string]json.RawMessage `json:"state"`
+}
+
+type event struct {
+    typ  string
+    data []byte
+}
+
+// Poller polls the rx.py terminal on behalf of all clients, caches the
+// latest payload of each message type and pushes changed payloads to
+// subscribers over SSE.
+type Poller struct {
+    client   *Client
+    interval time.Duration
+    mu       sync.Mutex
+    state    map[string]json.RawMessage
+    updated  time.Time
+    lastErr  error
+    clients  map[chan event]struct{}
+    quit     chan struct{}
+    quitOnce sync.Once
+
+    // Control channel activity, see noteControl
+    tsbkTotal   float64
+    lastControl time.Time
+}
+
+func NewPoller(client *Client, interval time.Duration) *Poller {
+    return &Poller{
+        client:   client,
+        interval: interval,
+        state:    make(map[string]json.RawMessage),
+        clients:  make(map[chan event]struct{}),
+        quit:     make(chan struct{}),
+    }
+}
+
+func (p *Poller) Start() {
+    log.Printf("State poller started for %s (every %s)", p.client.url, p.interval)
+    go func() {
+        // Config payloads only change on restart, fetch them once up front
+        p.poll(Command{Command: "get_terminal_config", Arg1: 0, Arg2: 0},
+            Command{Command: "get_full_config", Arg1: 0, Arg2: 0})
+
+        ticker := time.NewTicker(p.interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-p.quit:
+                return
+            case <-ticker.C:
+                p.poll(Command{Command: "update", Arg1: 0, Arg2: 0})
+            }
+        }
+    }()
+}
+
+func (p *Poller) poll(cmds ...Command) {
+    msgs, err := p.client.Send(cmds...)
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if err != nil {
+        // Log only the first failure of a streak; rx.py takes a while to come up
+        if p.lastErr == nil {
+            log.Printf("State poller: %v", err)
+        }
+        p.lastErr = err
+        return
+    }
+    if p.lastErr != nil {
+        log.Printf("State poller: rx.py terminal reachable again")
+        p.lastErr = nil
+    }
+    p.updated = time.Now()
+    p.ingest(msgs)
+}
+
+// Ingest merges messages obtained outside the poll loop (e.g. command
+// replies) into the cache.
+func (p *Poller) Ingest(msgs []Message) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.ingest(msgs)
+}
+
+func (p *Poller) ingest(msgs []Message) {
+    for _, msg := range msgs {
+        if !cachedTypes[msg.JSONType] {
+            continue
+        }
+        if msg.JSONType == "trunk_update" {
+            p.noteControl(msg.Data)
+        }
+        if bytes.Equal(p.state[msg.JSONType], msg.Data) {
+            continue
+        }
+        p.state[msg.JSONType] = msg.Data
+        p.publish(event{typ: msg.JSONType, data: msg.Data})
+    }
+}
+
+// publish must be called with p.mu held. Clients too slow to keep up are
+// disconnected rather than silently missing a diff; they resync on reconnect.
+func (p *Poller) publish(ev event) {
+    for ch := range p.clients {
+        select {
+        case ch <- ev:
+        default:
+            delete(p.clients, ch)
+            close(ch)
+        }
+    }
+}
+
+func (p *Poller) Snapshot() Snapshot {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    snap := Snapshot{
+        Updated: p.updated,
+        State:   make(map[string]json.RawMessage, len(p.state)),
+    }
+    if p.lastErr != nil {
+        snap.Error = p.lastErr.Error()
+    }
+    for k, v := range p.state {
+        snap.State[k] = v
+    }
+    return snap
+}
+
+// Clients returns the number of connected SSE subscribers.
+func (p *Poller) Clients() int {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return len(p.clients)
+}
+
+// ServeSnapshot writes the full cached state as JSON.
+func (p *Poller) ServeSnapshot(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    _ = json.NewEncoder(w).Encode(p.Snapshot())
+}
+
+// ServeSSE streams the cached state followed by every changed payload, one
+// SSE event per message type.
+func (p *Poller) ServeSSE(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+    w.Header().Set("Access-Control-Allow-Origin", "*")
+
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+        return
+    }
+
+    ch := make(chan event, 32)
+
+    p.mu.Lock()
+    for typ, data := range p.state {
+        writeEvent(w, event{typ: typ, data: data})
+    }
+    flusher.Flush()
+    p.clients[ch] = struct{}{}
+    p.mu.Unlock()
+
+    defer func() {
+        p.mu.Lock()
+        if _, ok := p.clients[ch]; ok {
+            delete(p.clients, ch)
+            close(ch)
+        }
+        p.mu.Unlock()
+    }()
+
+    notify := r.Context().Done()
+    for {
+        select {
+        case ev, ok := <-ch:
+            if !ok {
+                // Closed by Shutdown rather than for falling behind
+                select {
+                case <-p.quit:
+                    writeEvent(w, event{typ: "shutdown", data: []byte("state feed closed")})
+                    flusher.Flush()
+                default:
+                }
+                return
+            }
+            writeEvent(w, ev)
+            flusher.Flush()
+        case <-notify:
+            return
+        }
+    }
+}
+
+func writeEvent(w http.ResponseWriter, ev event) {
+    fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.typ, ev.data)
+}
+
+// Shutdown stops polling and ends all SSE streams with a shutdown event. It
+// is safe to call more than once.
+func (p *Poller) Shutdown() {
+    p.quitOnce.Do(func() {
+        close(p.quit)
+    })
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    for ch := range p.clients {
+        delete(p.clients, ch)
+        close(ch)
+    }
+}
@@ -0,0 +1,25 @@
+package terminal
+
+import (
+    "log"
+    "net/http"
+    "net/http/httputil"
+    "net/url"
+)
+
+// NewProxy returns a reverse proxy to the rx.py HTTP terminal at addr.
+// Requests are forwarded with their path unchanged, so callers mounting it
+// under a prefix should strip the prefix first.
+func NewProxy(addr string) *httputil.ReverseProxy {
+    target := &url.URL{Scheme: "http", Host: addr}
+    return &httputil.ReverseProxy{
+        Rewrite: func(r *httputil.ProxyRequest) {
+            r.SetURL(target)
+            r.SetXForwarded()
+        },
+        ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+            log.Printf("OP25 terminal proxy error: %v", err)
+            http.Error(w, "OP25 HTTP terminal unreachable", http.StatusBadGateway)
+        },
+    }
+}
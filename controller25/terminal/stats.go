@@ -0,0 +1,63 @@
+package terminal
+
+import (
+    "encoding/json"
+    "time"
+)
+
+// DecoderStats are decoder figures extracted from the cached rx.py state.
+// There is no decode error rate: rx.py reports no error count, and the
+// "error" in rx_update is the tuning offset in Hz, not a decoding figure.
+type DecoderStats struct {
+    // TSBKs decoded per system, keyed by NAC as reported by rx.py
+    TSBKs map[string]float64
+}
+
+// DecoderStats parses the latest trunk_update payload. Missing or
+// unrecognized fields are left out rather than reported as zero.
+func (p *Poller) DecoderStats() DecoderStats {
+    p.mu.Lock()
+    trunk := p.state["trunk_update"]
+    p.mu.Unlock()
+
+    return DecoderStats{TSBKs: tsbks(trunk)}
+}
+
+// LastControl returns when rx.py last reported more TSBKs decoded, i.e. when
+// it was last seen decoding a control channel. Zero if it never was.
+func (p *Poller) LastControl() time.Time {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return p.lastControl
+}
+
+// noteControl records control channel activity if trunk, a trunk_update
+// payload, counts more TSBKs than the previous one. Must be called with
+// p.mu held.
+func (p *Poller) noteControl(trunk json.RawMessage) {
+    var total float64
+    for _, n := range tsbks(trunk) {
+        total += n
+    }
+    if total > p.tsbkTotal {
+        p.lastControl = time.Now()
+    }
+    p.tsbkTotal = total
+}
+
+// tsbks returns the TSBK count of each system in a trunk_update payload.
+func tsbks(trunk json.RawMessage) map[string]float64 {
+    counts := make(map[string]float64)
+    var systems map[string]json.RawMessage
+    if json.Unmarshal(trunk, &systems) == nil {
+        for nac, data := range systems {
+            var sys struct {
+                Tsbks *float64 `json:"tsbks"`
+            }
+            if json.Unmarshal(data, &sys) == nil && sys.Tsbks != nil {
+                counts[nac] = *sys.Tsbks
+            }
+        }
+    }
+    return counts
+}
@@ -0,0 +1,44 @@
+package terminal
+
+import (
+    "net"
+    "strings"
+)
+
+// ParseAddr returns the address of the rx.py HTTP terminal requested by
+// "-l http:host:port" (or "--terminal-type") in flags, suitable for dialing
+// from the controller. Returns "" if rx.py was not started with an HTTP terminal.
+func ParseAddr(flags []string) string {
+    var value string
+    for i, flag := range flags {
+        switch {
+        case (flag == "-l" || flag == "--terminal-type") && i+1 < len(flags):
+            value = flags[i+1]
+        case strings.HasPrefix(flag, "--terminal-type="):
+            value = strings.TrimPrefix(flag, "--terminal-type=")
+        }
+    }
+    value = strings.Trim(value, `'"`)
+    if !strings.HasPrefix(value, "http:") {
+        return ""
+    }
+
+    // rx.py accepts both http:host:port and http:port
+    parts := strings.Split(strings.TrimPrefix(value, "http:"), ":")
+    host, port := "", ""
+    switch len(parts) {
+    case 1:
+        port = parts[0]
+    case 2:
+        host, port = parts[0], parts[1]
+    default:
+        return ""
+    }
+    if port == "" {
+        return ""
+    }
+    if host == "" || host == "0.0.0.0" {
+        host = "127.0.0.1"
+    }
+    return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,5 @@
+package version
+
+// Version is the controller version reported over mDNS and the API.
+// Release builds override it with -ldflags "-X controller25/version.Version=...".
+var Version = "1.1.0"